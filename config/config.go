@@ -5,113 +5,465 @@ import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"os"
-	"encoding/json"
 	"crypto/rsa"
 	"io/ioutil"
-	"github.com/dgrijalva/jwt-go"
+	"golang-jwt-api/keys"
+	"golang-jwt-api/hash"
+	"golang-jwt-api/cookies"
+	"net/http"
+	"strings"
 )
 
-type MysqlConfig struct {
+// Database dialects, as named by gorm.
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite3"
+)
+
+// DatabaseConfig selects the database the services are stored
+// in. For SQLite, Name is the path of the database file, or
+// ":memory:" for a database that lives as long as the process.
+type DatabaseConfig struct {
+	// Type is "mysql", the default, "postgres" or "sqlite3".
+	Type     string `json:"type"`
 	Host     string `json:"host"`
+	// Port defaults to 3306 for MySQL and 5432 for PostgreSQL.
 	Port     int    `json:"port"`
 	User     string `json:"user"`
-	Password string `json:"password"`
+	Password string `json:"password" secret:"true"`
 	Name     string `json:"name"`
+	// SSLMode is the PostgreSQL sslmode, "require" by default.
+	SSLMode  string `json:"ssl_mode"`
 }
 
 type JwtConfig struct {
 	PrivateKey string `json:"private"`
 	PublicKey  string `json:"public"`
+	// PrivateKeyPassphrase decrypts an encrypted PKCS#8
+	// private key.
+	PrivateKeyPassphrase string `json:"private_passphrase" secret:"true"`
+	// Leeway is the clock skew tolerated when validating the
+	// "exp", "nbf" and "iat" claims.
+	Leeway     Duration `json:"leeway"`
+	// RequiredClaims overrides the claims every token must
+	// carry, e.g. ["exp", "iat", "iss", "sub"]. Only the
+	// registered claims in tokenClaims can be required.
+	RequiredClaims []string `json:"required_claims"`
+	// Audience identifies this service. Only tokens issued for
+	// it are accepted.
+	Audience   string   `json:"audience"`
+	// Audiences lists the downstream services clients may
+	// request tokens for at login.
+	Audiences  []string `json:"audiences"`
+	// Issuer is the "iss" claim of issued tokens.
+	Issuer     string   `json:"issuer"`
+	Lifetimes  TokenLifetimesConfig `json:"lifetimes"`
+	// Clients overrides the lifetimes for OAuth clients, keyed
+	// by client ID. Only clients that authenticate at the token
+	// endpoint get them; logins cannot name a client.
+	Clients    map[string]TokenLifetimesConfig `json:"clients"`
+	// Stateless trusts the signed claims of tokens instead of
+	// loading the user on every request. Revocations reach
+	// other instances within RevocationCacheTTL.
+	Stateless  bool     `json:"stateless"`
+	RevocationCacheTTL Duration `json:"revocation_cache_ttl"`
+}
+
+// TokenLifetimesConfig holds how long each kind of token is
+// valid. Missing values fall back to the defaults.
+type TokenLifetimesConfig struct {
+	Access       Duration `json:"access"`
+	MFAChallenge Duration `json:"mfa_challenge"`
+	EmailLink    Duration `json:"email_link"`
+	Service      Duration `json:"service"`
+}
+
+type Argon2Config struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// PasswordConfig selects the algorithm used to hash new
+// passwords. Hashes produced by the other supported
+// algorithms are still accepted and upgraded on login.
+type PasswordConfig struct {
+	Algorithm  string       `json:"algorithm"`
+	BcryptCost int          `json:"bcrypt_cost"`
+	Argon2     Argon2Config `json:"argon2"`
+}
+
+// PepperConfig is a single versioned pepper. Retired
+// peppers must be kept until no stored password hash uses
+// them anymore.
+type PepperConfig struct {
+	Version int    `json:"version"`
+	Value   string `json:"value" secret:"true"`
+}
+
+// HMACKeyConfig is a single HMAC key. Retired keys must be
+// kept as long as digests created with them are stored.
+type HMACKeyConfig struct {
+	ID  string `json:"id"`
+	Key string `json:"key" secret:"true"`
+}
+
+// SecurityConfig holds switches that trade convenience for
+// protection against probing of registered accounts.
+type SecurityConfig struct {
+	ConcealAccounts bool `json:"conceal_accounts"`
+}
+
+// UserCacheConfig configures the cache in front of the users
+// table. A size of zero disables it; otherwise TTL is required.
+type UserCacheConfig struct {
+	Size int      `json:"size"`
+	TTL  Duration `json:"ttl"`
+}
+
+// LoginHistoryConfig configures how long login attempts are
+// kept. A retention of zero keeps them forever.
+type LoginHistoryConfig struct {
+	Retention Duration `json:"retention"`
+}
+
+// ClientCredentialsConfig identifies a client allowed to
+// call an endpoint protected by client credentials.
+type ClientCredentialsConfig struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret" secret:"true"`
+}
+
+// IntrospectionConfig lists the clients allowed to call the
+// token introspection endpoint.
+type IntrospectionConfig struct {
+	Clients []ClientCredentialsConfig `json:"clients"`
+}
+
+// OAuthConfig restricts the service accounts users may
+// register.
+type OAuthConfig struct {
+	// ServiceAccountOwners lists the IDs of the users allowed to
+	// register service accounts. Nobody is by default.
+	ServiceAccountOwners []uint `json:"service_account_owners"`
+	// ServiceAccountScopes lists the scopes service accounts may
+	// be registered with, such as "users:read".
+	ServiceAccountScopes []string `json:"service_account_scopes"`
+}
+
+// OIDCConfig enables the OpenID Connect endpoints and ID
+// tokens. They need jwt.issuer to be the https:// URL the
+// service is reachable at, which relying parties compare with
+// the issuer of ID tokens.
+type OIDCConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// CookieConfig enables the cookie session mode for browser
+// clients, which ask for it at login with cookie=true.
+type CookieConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Name     string `json:"name"`
+	CSRFName string `json:"csrf_name"`
+	Domain   string `json:"domain"`
+	// SameSite is "lax" (the default), "strict" or "none",
+	// which cannot be combined with Insecure.
+	SameSite string `json:"same_site"`
+	// Insecure allows the cookies over plain HTTP for local
+	// development.
+	Insecure bool   `json:"insecure"`
 }
 
 type Config struct {
 	Port     int             `json:"port"`
 	Env      string          `json:"env"`
-	Pepper   string          `json:"pepper"`
-	HMACKey  string          `json:"hmac_key"`
-	Database MysqlConfig 	 `json:"database"`
+	Pepper   string          `json:"pepper" secret:"true"`
+	Peppers  []PepperConfig  `json:"peppers"`
+	PepperVersion int        `json:"pepper_version"`
+	HMACKey  string          `json:"hmac_key" secret:"true"`
+	HMACKeys []HMACKeyConfig `json:"hmac_keys"`
+	HMACKeyID string         `json:"hmac_key_id"`
+	Password PasswordConfig  `json:"password"`
+	Security SecurityConfig  `json:"security"`
+	Database DatabaseConfig  `json:"database"`
+	UserCache UserCacheConfig `json:"user_cache"`
+	LoginHistory LoginHistoryConfig `json:"login_history"`
+	Introspection IntrospectionConfig `json:"introspection"`
+	OAuth    OAuthConfig     `json:"oauth"`
+	OIDC     OIDCConfig      `json:"oidc"`
+	Cookie   CookieConfig    `json:"cookie"`
 	Jwt      JwtConfig   	 `json:"jwt"`
 }
 
-func LoadConfig() Config {
-	f, err := os.Open(".config")
-	if err != nil {
-		panic(err)
+func (c Config) IsProd() bool {
+	return c.Env == "prod"
+}
+
+// EnvTestConfigPath holds the path of the configuration used
+// by tests needing a database. Tests of the models package
+// only use a database when it is set. LoadTestConfig defaults
+// to ../.config_test, relative to the package under test.
+const EnvTestConfigPath = "APP_TEST_CONFIG"
+
+// LoadTestConfig loads the configuration used by tests the
+// same way Load does, from $APP_TEST_CONFIG or ../.config_test.
+func LoadTestConfig() (Config, error) {
+	path, ok := os.LookupEnv(EnvTestConfigPath)
+	if !ok {
+		path = "../.config_test"
 	}
-	var c Config
-	dec := json.NewDecoder(f)
-	err = dec.Decode(&c)
-	if err != nil {
-		panic(err)
+	return Load(Flags{ConfigPath: path}, os.LookupEnv)
+}
+
+// Dialect returns the gorm dialect of the database, accepting
+// "postgresql" and "sqlite" as aliases.
+func (c DatabaseConfig) Dialect() string {
+	switch strings.ToLower(c.Type) {
+	case "", MySQL:
+		return MySQL
+	case Postgres, "postgresql":
+		return Postgres
+	case SQLite, "sqlite":
+		return SQLite
 	}
-	fmt.Println("Successfully loaded .config")
-	return c
+	return c.Type
 }
 
-func (c Config) IsProd() bool {
-	return c.Env == "prod"
+// ConnectionInfo returns the data source name gorm.Open
+// expects for the dialect.
+func (c DatabaseConfig) ConnectionInfo() string {
+	switch c.Dialect() {
+	case Postgres:
+		port := c.Port
+		if port == 0 {
+			port = 5432
+		}
+		sslMode := c.SSLMode
+		if sslMode == "" {
+			sslMode = "require"
+		}
+		info := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
+			pqQuote(c.Host), port, pqQuote(c.User), pqQuote(c.Name), pqQuote(sslMode))
+		if c.Password != "" {
+			info += " password=" + pqQuote(c.Password)
+		}
+		return info
+	case SQLite:
+		// Every connection to ":memory:" opens its own database;
+		// sharing the cache lets the connection pool share one.
+		if c.Name == ":memory:" {
+			return "file::memory:?cache=shared&_busy_timeout=5000"
+		}
+		return c.Name + "?_busy_timeout=5000"
+	}
+	userInfo := c.User
+	if c.Password != "" {
+		userInfo += ":" + c.Password
+	}
+	if c.Host == "" {
+		return fmt.Sprintf("%s@/%s?parseTime=true", userInfo, c.Name)
+	}
+	port := c.Port
+	if port == 0 {
+		port = 3306
+	}
+	return fmt.Sprintf("%s@tcp(%s:%d)/%s?parseTime=true", userInfo, c.Host, port, c.Name)
 }
 
-func LoadTestConfig() Config {
-	f, err := os.Open("../.config_test")
+// pqQuote quotes a value of a PostgreSQL connection string if
+// it is empty or contains spaces, quotes or backslashes.
+func pqQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
+
+// GetMockDatabase connects to the test database.
+func GetMockDatabase(config DatabaseConfig) (*gorm.DB, error) {
+	return gorm.Open(config.Dialect(), config.ConnectionInfo())
+}
+
+
+// GetPrivateKey reads the private key tokens are signed with,
+// decrypting it with "private_passphrase" if needed.
+func (c Config) GetPrivateKey() (*rsa.PrivateKey, error) {
+	keyData, err := ioutil.ReadFile(c.Jwt.PrivateKey)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("config: jwt.private: %v", err)
 	}
-	var c Config
-	dec := json.NewDecoder(f)
-	err = dec.Decode(&c)
+	key, err := keys.ParsePrivateKeyPEM(keyData, c.Jwt.PrivateKeyPassphrase)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("config: jwt.private: %v", err)
 	}
-	fmt.Println("Successfully loaded .config")
-	return c
+	return key, nil
 }
 
-func (c MysqlConfig) Dialect() string {
-	return "mysql"
+// GetPublicKey reads the public key tokens are verified with.
+func (c Config) GetPublicKey() (*rsa.PublicKey, error) {
+	keyData, err := ioutil.ReadFile(c.Jwt.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("config: jwt.public: %v", err)
+	}
+	key, err := keys.ParsePublicKeyPEM(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("config: jwt.public: %v", err)
+	}
+	return key, nil
 }
 
-func (c MysqlConfig) ConnectionInfo() string {
-	if c.Password == "" {
-		return fmt.Sprintf("%s@/%s?parseTime=true", c.User, c.Name)
+// GetKeys reads both keys and makes sure they belong together.
+func (c Config) GetKeys() (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	private, err := c.GetPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	public, err := c.GetPublicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !keys.Matches(private, public) {
+		return nil, nil, fmt.Errorf("config: jwt.public is not the public key of jwt.private")
 	}
-	return fmt.Sprintf("%s:%s@/%s?parseTime=true", c.User, c.Password, c.Name)
+	return private, public, nil
 }
 
-
-func GetMockDatabase(config MysqlConfig) *gorm.DB{
-	testCfg := LoadTestConfig()
-	testDbCfg := testCfg.Database
-	dbConnect, err := gorm.Open(config.Dialect(),testDbCfg.ConnectionInfo())
+// GetPasswordHasher returns the hasher for the configured
+// algorithm, defaulting to bcrypt. Both bcrypt and Argon2id
+// hashes are always accepted so users can be migrated
+// between them transparently. It panics if the configuration
+// was not validated.
+func (c Config) GetPasswordHasher() hash.PasswordHasher {
+	hasher, err := c.passwordHasher()
 	if err != nil {
-		panic(err)
+		panic(err.Error())
 	}
-
-	return dbConnect
+	return hasher
 }
 
+func (c Config) passwordHasher() (hash.PasswordHasher, error) {
+	bcryptHasher := hash.NewBcrypt(c.Password.BcryptCost)
+	argon2Cfg := c.Password.Argon2
+	argon2Hasher := hash.NewArgon2id(argon2Cfg.Time, argon2Cfg.Memory, argon2Cfg.Threads)
+	switch c.Password.Algorithm {
+	case "", "bcrypt":
+		return hash.NewPasswords(bcryptHasher, argon2Hasher), nil
+	case "argon2id":
+		return hash.NewPasswords(argon2Hasher, bcryptHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown password algorithm %q", c.Password.Algorithm)
+	}
+}
 
-func (c Config) GetPrivateKey() *rsa.PrivateKey {
-	keyData, err := ioutil.ReadFile(c.Jwt.PrivateKey)
+// GetPeppers returns every configured pepper keyed by version
+// along with the version used for new password hashes. The
+// legacy "pepper" value is treated as version 0. It panics if
+// the configuration was not validated.
+func (c Config) GetPeppers() (map[int]string, int) {
+	peppers, current, err := c.peppers()
 	if err != nil {
 		panic(err.Error())
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyData)
+	return peppers, current
+}
+
+func (c Config) peppers() (map[int]string, int, error) {
+	peppers := map[int]string{0: c.Pepper}
+	for _, p := range c.Peppers {
+		peppers[p.Version] = p.Value
+	}
+	if _, ok := peppers[c.PepperVersion]; !ok {
+		return nil, 0, fmt.Errorf("pepper version %d is not configured", c.PepperVersion)
+	}
+	return peppers, c.PepperVersion, nil
+}
+
+// GetHMAC returns the keyed HMAC set using the key with ID
+// "hmac_key_id" for new digests. The legacy "hmac_key" value
+// is kept under the empty key ID. It panics if the
+// configuration was not validated.
+func (c Config) GetHMAC() hash.KeyedHMAC {
+	hmac, err := c.keyedHMAC()
 	if err != nil {
 		panic(err.Error())
 	}
-	return key
+	return hmac
 }
 
-func (c Config) GetPublicKey() *rsa.PublicKey{
-	keyData, err := ioutil.ReadFile(c.Jwt.PublicKey)
+func (c Config) keyedHMAC() (hash.KeyedHMAC, error) {
+	keys := make(map[string]string, len(c.HMACKeys)+1)
+	if c.HMACKey != "" {
+		keys[""] = c.HMACKey
+	}
+	for _, k := range c.HMACKeys {
+		keys[k.ID] = k.Key
+	}
+	// An empty current key would compute every digest without
+	// a secret.
+	if key, ok := keys[c.HMACKeyID]; ok && key == "" {
+		return hash.KeyedHMAC{}, fmt.Errorf("hmac_key_id %q: the key is empty", c.HMACKeyID)
+	}
+	if _, ok := keys[c.HMACKeyID]; !ok && c.HMACKeyID == "" {
+		return hash.KeyedHMAC{}, fmt.Errorf("hmac_key_id is required when hmac_key is not set")
+	}
+	hmac, err := hash.NewKeyedHMAC(keys, c.HMACKeyID)
 	if err != nil {
-		panic(err.Error())
+		return hash.KeyedHMAC{}, fmt.Errorf("hmac_key_id %q: %v", c.HMACKeyID, err)
 	}
-	key, err := jwt.ParseRSAPublicKeyFromPEM(keyData)
+	return hmac, nil
+}
+
+// GetTokenCookie returns the token cookie of the cookie
+// session mode, or nil if it is disabled. It panics if the
+// configuration was not validated.
+func (c Config) GetTokenCookie() *cookies.TokenCookie {
+	cookie, err := c.tokenCookie()
 	if err != nil {
 		panic(err.Error())
 	}
-	return key
+	return cookie
 }
 
+func (c Config) tokenCookie() (*cookies.TokenCookie, error) {
+	if !c.Cookie.Enabled {
+		return nil, nil
+	}
+	cookie := cookies.New()
+	if c.Cookie.Name != "" {
+		cookie.Name = c.Cookie.Name
+	}
+	if c.Cookie.CSRFName != "" {
+		cookie.CSRFName = c.Cookie.CSRFName
+	}
+	cookie.Domain = c.Cookie.Domain
+	cookie.Secure = !c.Cookie.Insecure
+	switch c.Cookie.SameSite {
+	case "", "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers reject SameSite=None cookies that are not
+		// Secure, so logins would silently fail.
+		if c.Cookie.Insecure {
+			return nil, fmt.Errorf(`cookie.same_site "none" requires secure cookies, drop cookie.insecure`)
+		}
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("unknown cookie.same_site %q", c.Cookie.SameSite)
+	}
+	return cookie, nil
+}
+
+// GetIntrospectionClients returns the secrets of the clients
+// allowed to introspect tokens, keyed by client ID.
+func (c Config) GetIntrospectionClients() map[string]string {
+	clients := make(map[string]string, len(c.Introspection.Clients))
+	for _, client := range c.Introspection.Clients {
+		clients[client.ID] = client.Secret
+	}
+	return clients
+}
@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestDatabaseConfig_ConnectionInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		db          DatabaseConfig
+		wantDialect string
+		want        string
+	}{
+		{"MySQL by default", DatabaseConfig{Host: "db", Port: 3306, User: "famistar", Password: "hunter2", Name: "famistar"},
+			MySQL, "famistar:hunter2@tcp(db:3306)/famistar?parseTime=true"},
+		{"MySQL over the default address", DatabaseConfig{User: "famistar", Name: "famistar"},
+			MySQL, "famistar@/famistar?parseTime=true"},
+		{"PostgreSQL", DatabaseConfig{Type: "postgresql", Host: "db", Port: 5432, User: "famistar", Password: "it's secret", Name: "famistar", SSLMode: "disable"},
+			Postgres, `host=db port=5432 user=famistar dbname=famistar sslmode=disable password='it\'s secret'`},
+		{"MySQL on the default port", DatabaseConfig{Host: "db", User: "famistar", Name: "famistar"},
+			MySQL, "famistar@tcp(db:3306)/famistar?parseTime=true"},
+		{"PostgreSQL on the default port", DatabaseConfig{Type: "postgres", Host: "db", User: "famistar", Name: "famistar", SSLMode: "disable"},
+			Postgres, "host=db port=5432 user=famistar dbname=famistar sslmode=disable"},
+		{"PostgreSQL requires TLS by default", DatabaseConfig{Type: "postgres", Host: "db", Port: 5432, User: "famistar", Name: "famistar"},
+			Postgres, "host=db port=5432 user=famistar dbname=famistar sslmode=require"},
+		{"SQLite file", DatabaseConfig{Type: "sqlite", Name: "famistar.db"},
+			SQLite, "famistar.db?_busy_timeout=5000"},
+		{"SQLite in memory", DatabaseConfig{Type: "sqlite3", Name: ":memory:"},
+			SQLite, "file::memory:?cache=shared&_busy_timeout=5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.db.Dialect(); got != tt.wantDialect {
+				t.Errorf("Dialect() = %q; want %q", got, tt.wantDialect)
+			}
+			if got := tt.db.ConnectionInfo(); got != tt.want {
+				t.Errorf("ConnectionInfo() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
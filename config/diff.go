@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Change is a setting that differs between two configurations.
+// Old and New are formatted as JSON, with secrets redacted.
+type Change struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+// Diff lists the settings changed from old to new, by the path
+// of their JSON keys, e.g. "jwt.lifetimes.access". Lists and
+// maps are compared as a whole.
+func Diff(old, new Config) []Change {
+	var changes []Change
+	diff(reflect.ValueOf(old), reflect.ValueOf(new),
+		reflect.ValueOf(old.Redacted()), reflect.ValueOf(new.Redacted()), "", &changes)
+	return changes
+}
+
+// diff compares old and new, and formats the changes found
+// with their redacted counterparts.
+func diff(old, new, redactedOld, redactedNew reflect.Value, path string, changes *[]Change) {
+	if old.Kind() == reflect.Struct && old.Type() != durationType {
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			diff(old.Field(i), new.Field(i), redactedOld.Field(i), redactedNew.Field(i), name, changes)
+		}
+		return
+	}
+	if reflect.DeepEqual(old.Interface(), new.Interface()) {
+		return
+	}
+	*changes = append(*changes, Change{
+		Path: path,
+		Old:  formatValue(redactedOld),
+		New:  formatValue(redactedNew),
+	})
+}
+
+func formatValue(v reflect.Value) string {
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(b)
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old := Default()
+	old.Pepper = "old-pepper"
+	old.Jwt.Audiences = []string{"billing"}
+	old.Jwt.Lifetimes.Access = Duration{time.Hour}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Diff() of identical configurations = %v; want none", changes)
+	}
+
+	new := old
+	new.Pepper = "new-pepper"
+	new.Jwt.Audiences = []string{"billing", "reports"}
+	new.Jwt.Lifetimes.Access = Duration{15 * time.Minute}
+
+	want := []Change{
+		{Path: "pepper", Old: `"REDACTED"`, New: `"REDACTED"`},
+		{Path: "jwt.audiences", Old: `["billing"]`, New: `["billing","reports"]`},
+		{Path: "jwt.lifetimes.access", Old: `"1h0m0s"`, New: `"15m0s"`},
+	}
+	if got := Diff(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v; want %v", got, want)
+	}
+}
+
+func TestPath(t *testing.T) {
+	if got := Path(Flags{ConfigPath: "flag.json"}, env(map[string]string{EnvConfigPath: "env.json"})); got != "flag.json" {
+		t.Errorf("Path() = %q; want the flag", got)
+	}
+	if got := Path(Flags{}, env(map[string]string{EnvConfigPath: "env.json"})); got != "env.json" {
+		t.Errorf("Path() = %q; want $%s", got, EnvConfigPath)
+	}
+	if got := Path(Flags{}, env(nil)); got != DefaultConfigPath {
+		t.Errorf("Path() = %q; want %q", got, DefaultConfigPath)
+	}
+}
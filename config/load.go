@@ -0,0 +1,412 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvPrefix starts the environment variables overriding the
+	// configuration. The rest of the name is the path of JSON
+	// keys in upper case, e.g. APP_DATABASE_PASSWORD.
+	EnvPrefix = "APP"
+	// EnvConfigPath holds the path of the configuration file if
+	// the -config flag is not given.
+	EnvConfigPath = "APP_CONFIG"
+	// DefaultConfigPath is read if it exists and no path was
+	// given.
+	DefaultConfigPath = ".config"
+
+	redacted = "REDACTED"
+)
+
+// Flags are the command line flags of the server. Flags that
+// are set override both the configuration file and the
+// environment.
+type Flags struct {
+	ConfigPath  string
+	Port        int
+	Env         string
+	PrintConfig bool
+	// Args are the arguments left after the flags, naming a
+	// command such as "migrate up".
+	Args []string
+}
+
+// ParseFlags parses the command line arguments, without the
+// program name.
+func ParseFlags(args []string) (Flags, error) {
+	var f Flags
+	fs := flag.NewFlagSet("famistar", flag.ContinueOnError)
+	fs.StringVar(&f.ConfigPath, "config", "", "path of the JSON configuration file (default $"+EnvConfigPath+" or "+DefaultConfigPath+")")
+	fs.IntVar(&f.Port, "port", 0, "port to listen on")
+	fs.StringVar(&f.Env, "env", "", `environment, "dev" or "prod"`)
+	fs.BoolVar(&f.PrintConfig, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	if err := fs.Parse(args); err != nil {
+		return Flags{}, err
+	}
+	f.Args = fs.Args()
+	return f, nil
+}
+
+// Default returns the configuration every layer is applied on.
+func Default() Config {
+	return Config{
+		Port: 3000,
+		Env:  "dev",
+		Database: DatabaseConfig{
+			Host: "localhost",
+		},
+		LoginHistory: LoginHistoryConfig{
+			Retention: Duration{90 * 24 * time.Hour},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the
+// configuration file, environment variables and flags, each
+// layer overriding the previous ones, and validates it.
+// Secrets given as "file:<path>" are then read from that file.
+//
+// The file is the one given by the -config flag, else by
+// $APP_CONFIG, else .config if it exists. lookupEnv is usually
+// os.LookupEnv. If only validation fails, the configuration is
+// returned along with the error so it can still be printed.
+func Load(flags Flags, lookupEnv func(string) (string, bool)) (Config, error) {
+	c := Default()
+
+	path, explicit := configPath(flags, lookupEnv)
+	if err := c.readFile(path); err != nil {
+		if !explicit && os.IsNotExist(err) {
+			err = nil
+		} else {
+			return Config{}, fmt.Errorf("config: reading %s: %v", path, err)
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), EnvPrefix, lookupEnv); err != nil {
+		return Config{}, err
+	}
+	if err := resolveSecrets(reflect.ValueOf(&c).Elem(), ""); err != nil {
+		return Config{}, err
+	}
+
+	if flags.Port != 0 {
+		c.Port = flags.Port
+	}
+	if flags.Env != "" {
+		c.Env = flags.Env
+	}
+	return c, c.Validate()
+}
+
+// Path returns the path of the configuration file Load reads.
+func Path(flags Flags, lookupEnv func(string) (string, bool)) string {
+	path, _ := configPath(flags, lookupEnv)
+	return path
+}
+
+// configPath also reports whether the path was given, in which
+// case the file must exist.
+func configPath(flags Flags, lookupEnv func(string) (string, bool)) (string, bool) {
+	if flags.ConfigPath != "" {
+		return flags.ConfigPath, true
+	}
+	if path, ok := lookupEnv(EnvConfigPath); ok {
+		return path, true
+	}
+	return DefaultConfigPath, false
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(c)
+}
+
+// applyEnv overrides the fields of v, a struct, with the
+// environment variables named after their JSON keys. Strings,
+// booleans, numbers, durations and comma separated lists of
+// strings or unsigned numbers can be overridden; maps and lists
+// of objects cannot.
+func applyEnv(v reflect.Value, prefix string, lookupEnv func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(name)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			if err := applyEnv(field, key, lookupEnv); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookupEnv(key)
+		if path, isFile := lookupEnv(key + "_FILE"); isFile && isSecret(t.Field(i)) {
+			// Like the official Docker images, secrets can be
+			// read from the file named by <NAME>_FILE.
+			value, ok = secretFilePrefix+path, true
+		}
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return fmt.Errorf("config: %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+func setFromEnv(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		return field.Addr().Interface().(*Duration).UnmarshalJSON([]byte(strconv.Quote(value)))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Slice:
+		switch field.Type().Elem().Kind() {
+		case reflect.String, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if items == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFromEnv(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// secretFilePrefix marks secrets that are read from a file,
+// such as a Docker or Kubernetes secret mount.
+const secretFilePrefix = "file:"
+
+func isSecret(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+// resolveSecrets replaces the value of every secret of the
+// form "file:<path>" with the content of the file, without
+// trailing newlines.
+func resolveSecrets(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := path + jsonName(t.Field(i))
+			field := v.Field(i)
+			if isSecret(t.Field(i)) && field.Kind() == reflect.String {
+				if !strings.HasPrefix(field.String(), secretFilePrefix) {
+					continue
+				}
+				b, err := ioutil.ReadFile(strings.TrimPrefix(field.String(), secretFilePrefix))
+				if err != nil {
+					return fmt.Errorf("config: %s: %v", name, err)
+				}
+				field.SetString(strings.TrimRight(string(b), "\r\n"))
+				continue
+			}
+			if err := resolveSecrets(field, name+"."); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecrets(v.Index(i), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidationError lists every problem found in a
+// configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "config: " + strings.Join(e.Problems, "; ")
+}
+
+// tokenClaims are the claims the token validator can require,
+// matching the Claim constants of the models package.
+var tokenClaims = []string{"exp", "nbf", "iat", "iss", "aud", "sub"}
+
+func isTokenClaim(name string) bool {
+	for _, claim := range tokenClaims {
+		if name == claim {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the required fields are set and that
+// the values the Get methods convert are valid, so they cannot
+// fail once the configuration has been loaded.
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.Port > 0 && c.Port < 65536, "port must be between 1 and 65535")
+	check(c.Env == "dev" || c.Env == "prod", `env must be "dev" or "prod"`)
+	switch c.Database.Dialect() {
+	case MySQL, Postgres:
+		check(c.Database.User != "", "database.user is required")
+		check(c.Database.Name != "", "database.name is required")
+	case SQLite:
+		check(c.Database.Name != "", "database.name is required, the path of the SQLite file or :memory:")
+	default:
+		problems = append(problems, fmt.Sprintf(`database.type %q is not "mysql", "postgres" or "sqlite3"`, c.Database.Type))
+	}
+	check(c.UserCache.Size >= 0, "user_cache.size must not be negative")
+	check(c.UserCache.Size == 0 || c.UserCache.TTL.Duration > 0, "user_cache.ttl must be positive when user_cache.size is set, or nothing is cached")
+	for _, name := range c.Jwt.RequiredClaims {
+		check(isTokenClaim(name), "jwt.required_claims: unknown claim %q, want one of %s", name, strings.Join(tokenClaims, ", "))
+	}
+	check(len(c.Jwt.Audiences) == 0 || c.Jwt.Audience != "", "jwt.audience is required when jwt.audiences is set, or tokens for every audience are accepted")
+	check(c.LoginHistory.Retention.Duration >= 0, "login_history.retention must not be negative")
+	check(c.Jwt.PrivateKey != "", "jwt.private is required")
+	check(c.Jwt.PublicKey != "", "jwt.public is required")
+	check(c.HMACKey != "" || len(c.HMACKeys) > 0, "hmac_key or hmac_keys is required")
+	check(!c.OIDC.Enabled || isHTTPSURL(c.Jwt.Issuer), "jwt.issuer must be an https:// URL when oidc.enabled is set")
+	for _, client := range c.Introspection.Clients {
+		check(client.ID != "", "introspection.clients: every client needs an id")
+		check(client.Secret != "", "introspection.clients: client %q needs a secret, or an empty password authenticates it", client.ID)
+	}
+	if _, err := c.passwordHasher(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, _, err := c.peppers(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := c.keyedHMAC(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := c.tokenCookie(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with the values
+// of fields tagged secret:"true" replaced.
+func (c Config) Redacted() Config {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := v.Field(i)
+			if isSecret(t.Field(i)) && field.Kind() == reflect.String {
+				if field.String() != "" {
+					field.SetString(redacted)
+				}
+				continue
+			}
+			redact(field)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		// Copy the slice so the original configuration keeps its
+		// secrets.
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		v.Set(copied)
+		for i := 0; i < v.Len(); i++ {
+			redact(v.Index(i))
+		}
+	}
+}
+
+// Print writes the configuration as JSON with its secrets
+// redacted.
+func (c Config) Print(w io.Writer) error {
+	b, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// isHTTPSURL reports whether s is an absolute https URL without
+// a query or fragment, as OpenID Connect requires of issuers.
+func isHTTPSURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != "" && u.RawQuery == "" && u.Fragment == ""
+}
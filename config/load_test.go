@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validConfig = `{
+  "env": "prod",
+  "pepper": "pepper",
+  "hmac_key": "hmac",
+  "database": {"user": "famistar", "password": "hunter2", "name": "famistar"},
+  "introspection": {"clients": [{"id": "billing", "secret": "s3cret"}]},
+  "jwt": {"private": "private.pem", "public": "public.pem", "leeway": "10s"}
+}`
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoad_Layers(t *testing.T) {
+	path := writeConfig(t, validConfig)
+	c, err := Load(Flags{ConfigPath: path, Port: 8080}, env(map[string]string{
+		"APP_PORT":                         "9000",
+		"APP_DATABASE_PASSWORD":            "from-env",
+		"APP_JWT_LEEWAY":                   "1m",
+		"APP_JWT_AUDIENCE":                 "api",
+		"APP_JWT_AUDIENCES":                "billing, reports",
+		"APP_COOKIE_ENABLED":               "true",
+		"APP_OAUTH_SERVICE_ACCOUNT_OWNERS": "1, 7",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case c.Port != 8080:
+		t.Errorf("Port = %d; want the flag value 8080", c.Port)
+	case c.Env != "prod":
+		t.Errorf("Env = %q; want the file value prod", c.Env)
+	case c.Database.Host != "localhost" || c.Database.Port != 0:
+		t.Errorf("Database = %+v; want the default host and no port", c.Database)
+	case !strings.Contains(c.Database.ConnectionInfo(), "localhost:3306"):
+		t.Errorf("ConnectionInfo() = %q; want the MySQL port", c.Database.ConnectionInfo())
+	case c.Database.Password != "from-env":
+		t.Errorf("Database.Password = %q; want the env value", c.Database.Password)
+	case c.Jwt.Leeway.Duration != time.Minute:
+		t.Errorf("Jwt.Leeway = %v; want 1m", c.Jwt.Leeway)
+	case strings.Join(c.Jwt.Audiences, "|") != "billing|reports":
+		t.Errorf("Jwt.Audiences = %q", c.Jwt.Audiences)
+	case !c.Cookie.Enabled:
+		t.Error("Cookie.Enabled was not set from the env")
+	case !reflect.DeepEqual(c.OAuth.ServiceAccountOwners, []uint{1, 7}):
+		t.Errorf("OAuth.ServiceAccountOwners = %v; want [1 7]", c.OAuth.ServiceAccountOwners)
+	}
+}
+
+func TestLoad_ConfigPathFromEnv(t *testing.T) {
+	path := writeConfig(t, validConfig)
+	c, err := Load(Flags{}, env(map[string]string{EnvConfigPath: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Env != "prod" {
+		t.Errorf("Env = %q; want prod", c.Env)
+	}
+}
+
+func TestLoad_OIDCIssuer(t *testing.T) {
+	path := writeConfig(t, validConfig)
+	for issuer, ok := range map[string]bool{
+		"https://auth.example.com":      true,
+		"https://example.com/auth":      true,
+		"http://auth.example.com":       false,
+		"https://auth.example.com/?a=b": false,
+		"famistar":                      false,
+	} {
+		_, err := Load(Flags{ConfigPath: path}, env(map[string]string{
+			"APP_OIDC_ENABLED": "true",
+			"APP_JWT_ISSUER":   issuer,
+		}))
+		if (err == nil) != ok {
+			t.Errorf("Load() with issuer %q = %v; want accepted %v", issuer, err, ok)
+		}
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		env     map[string]string
+		want    string
+	}{
+		{"Invalid JSON", `{"port": }`, nil, "reading"},
+		{"Invalid env value", validConfig, map[string]string{"APP_PORT": "http"}, "APP_PORT"},
+		{"Missing required fields", `{}`, nil, "database.user is required"},
+		{"Unknown password algorithm", validConfig, map[string]string{"APP_PASSWORD_ALGORITHM": "md5"}, "unknown password algorithm"},
+		{"Unknown HMAC key ID", validConfig, map[string]string{"APP_HMAC_KEY_ID": "2024"}, "hmac_key_id"},
+		{"HMAC keys without a current key ID", `{"database": {"user": "u", "name": "n"}, "hmac_keys": [{"id": "2024", "key": "k"}], "jwt": {"private": "p", "public": "p"}}`, nil, "hmac_key_id is required"},
+		{"Empty current HMAC key", `{"database": {"user": "u", "name": "n"}, "hmac_keys": [{"id": "2024", "key": ""}], "hmac_key_id": "2024", "jwt": {"private": "p", "public": "p"}}`, nil, "the key is empty"},
+		{"Introspection client without secret", `{"database": {"user": "u", "name": "n"}, "hmac_key": "k", "introspection": {"clients": [{"id": "billing"}]}, "jwt": {"private": "p", "public": "p"}}`, nil, `client "billing" needs a secret`},
+		{"Unknown database type", validConfig, map[string]string{"APP_DATABASE_TYPE": "oracle"}, "database.type"},
+		{"User cache without a TTL", validConfig, map[string]string{"APP_USER_CACHE_SIZE": "100"}, "user_cache.ttl"},
+		{"Negative login history retention", validConfig, map[string]string{"APP_LOGIN_HISTORY_RETENTION": "-1h"}, "login_history.retention"},
+		{"SameSite none over plain HTTP", validConfig, map[string]string{"APP_COOKIE_ENABLED": "true", "APP_COOKIE_SAME_SITE": "none", "APP_COOKIE_INSECURE": "true"}, "cookie.same_site"},
+		{"OpenID Connect without an https issuer", validConfig, map[string]string{"APP_OIDC_ENABLED": "true", "APP_JWT_ISSUER": "famistar"}, "jwt.issuer"},
+		{"Audiences without an own audience", validConfig, map[string]string{"APP_JWT_AUDIENCES": "billing"}, "jwt.audience"},
+		{"Unknown required claim", validConfig, map[string]string{"APP_JWT_REQUIRED_CLAIMS": "exp, jit"}, "jwt.required_claims"},
+		{"SQLite without a file", validConfig, map[string]string{"APP_DATABASE_TYPE": "sqlite3", "APP_DATABASE_NAME": ""}, "database.name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.content)
+			_, err := Load(Flags{ConfigPath: path}, env(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v; want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := Load(Flags{ConfigPath: "does-not-exist.json"}, env(nil)); err == nil {
+		t.Error("Load() of a missing explicit file should fail")
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	path := writeConfig(t, validConfig)
+	c, err := Load(Flags{ConfigPath: path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "s3cret", `"pepper": "pepper"`, `"hmac_key": "hmac"`} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Print() leaked %q", secret)
+		}
+	}
+	if !strings.Contains(out, `"user": "famistar"`) {
+		t.Errorf("Print() dropped non-secret values:\n%s", out)
+	}
+	if c.Introspection.Clients[0].Secret != "s3cret" || c.Database.Password != "hunter2" {
+		t.Error("Print() modified the configuration")
+	}
+}
+
+func TestLoad_SecretsFromFiles(t *testing.T) {
+	pepperFile := writeConfig(t, "pepper-from-file\n")
+	passwordFile := writeConfig(t, "password-from-file")
+	path := writeConfig(t, strings.Replace(validConfig, `"pepper": "pepper"`, `"pepper": "file:`+pepperFile+`"`, 1))
+
+	c, err := Load(Flags{ConfigPath: path}, env(map[string]string{
+		"APP_DATABASE_PASSWORD_FILE": passwordFile,
+		// Only secrets can be read from files.
+		"APP_DATABASE_NAME_FILE": passwordFile,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Pepper != "pepper-from-file" {
+		t.Errorf("Pepper = %q; want the content of the file", c.Pepper)
+	}
+	if c.Database.Password != "password-from-file" {
+		t.Errorf("Database.Password = %q; want the content of the file", c.Database.Password)
+	}
+	if c.Database.Name != "famistar" {
+		t.Errorf("Database.Name = %q; want the file value", c.Database.Name)
+	}
+
+	_, err = Load(Flags{ConfigPath: path}, env(map[string]string{"APP_HMAC_KEY": "file:/does/not/exist"}))
+	if err == nil || !strings.Contains(err.Error(), "hmac_key") {
+		t.Errorf("Load() error = %v; want it to mention hmac_key", err)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	f, err := ParseFlags([]string{"-config", "prod.json", "migrate", "down", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ConfigPath != "prod.json" || !reflect.DeepEqual(f.Args, []string{"migrate", "down", "2"}) {
+		t.Errorf("ParseFlags() = %+v; want the config path and the command", f)
+	}
+}
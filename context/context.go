@@ -6,7 +6,8 @@ import (
 )
 
 const (
-	userKey privateKey = "user"
+	userKey    privateKey = "user"
+	serviceKey privateKey = "service"
 )
 
 type privateKey string
@@ -23,3 +24,21 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+// WithService stores the service account a request was
+// authenticated as.
+func WithService(ctx context.Context, service *models.ServicePrincipal) context.Context {
+	return context.WithValue(ctx, serviceKey, service)
+}
+
+// Service returns the service account the request was
+// authenticated as, or nil if it was made by a user or
+// anonymously.
+func Service(ctx context.Context) *models.ServicePrincipal {
+	if temp := ctx.Value(serviceKey); temp != nil {
+		if service, ok := temp.(*models.ServicePrincipal); ok {
+			return service
+		}
+	}
+	return nil
+}
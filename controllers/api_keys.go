@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+	"golang-jwt-api/views"
+)
+
+type APIKeys struct {
+	aks models.APIKeyService
+}
+
+func NewAPIKeys(aks models.APIKeyService) *APIKeys {
+	return &APIKeys{
+		aks: aks,
+	}
+}
+
+type APIKeyForm struct {
+	Name  string `schema:"name"`
+	Scope string `schema:"scope"`
+	// ExpiresIn is a duration such as "720h". Keys without it
+	// never expire.
+	ExpiresIn string `schema:"expires_in"`
+}
+
+// currentKeyOwner returns the current user unless the request
+// was made with an API key, which must not be able to mint or
+// revoke other keys.
+func currentKeyOwner(r *http.Request) (*models.User, error) {
+	user := context.User(r.Context())
+	if user.Claims != nil && user.Claims.Kind == models.APIKeyToken {
+		return nil, models.ErrAPIKeyNotAllowed
+	}
+	return user, nil
+}
+
+// Create creates an API key for the current user. The key is
+// only returned in this response.
+//
+// POST /api-keys
+func (ak *APIKeys) Create(w http.ResponseWriter, r *http.Request) {
+	var form APIKeyForm
+	var vd views.Data
+	if err := parseForm(r, &form); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	user, err := currentKeyOwner(r)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+
+	key := models.APIKey{
+		UserID: user.ID,
+		Name:   form.Name,
+		Scope:  form.Scope,
+	}
+	if form.ExpiresIn != "" {
+		d, err := time.ParseDuration(form.ExpiresIn)
+		if err != nil || d <= 0 {
+			vd.SetError(models.ErrAPIKeyExpiryInvalid)
+			views.Render(w, r, vd)
+			return
+		}
+		expiresAt := time.Now().Add(d)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := ak.aks.Create(&key); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, key)
+}
+
+// Index lists the API keys of the current user.
+//
+// GET /api-keys
+func (ak *APIKeys) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	keys, err := ak.aks.ByUser(context.User(r.Context()).ID)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, keys)
+}
+
+// Revoke deletes one of the current user's API keys.
+//
+// DELETE /api-keys/{id}
+func (ak *APIKeys) Revoke(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user, err := currentKeyOwner(r)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		vd.SetError(models.ErrIDInvalid)
+		views.Render(w, r, vd)
+		return
+	}
+	if err := ak.aks.Revoke(user.ID, uint(id)); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, vd)
+}
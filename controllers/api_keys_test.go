@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+)
+
+// fakeAPIKeyService records the keys created through it.
+type fakeAPIKeyService struct {
+	models.APIKeyService
+	created []models.APIKey
+}
+
+func (f *fakeAPIKeyService) Create(key *models.APIKey) error {
+	key.Key = models.APIKeyPrefix + "secret"
+	f.created = append(f.created, *key)
+	return nil
+}
+
+func TestAPIKeys_Create(t *testing.T) {
+	tests := []struct {
+		name        string
+		kind        models.TokenKind
+		expiresIn   string
+		wantCreated bool
+	}{
+		{"Signed in with a token", models.AccessToken, "", true},
+		{"With expiry", models.AccessToken, "720h", true},
+		{"Invalid expiry", models.AccessToken, "-1h", false},
+		{"Signed in with an API key", models.APIKeyToken, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aks := &fakeAPIKeyService{}
+			ak := NewAPIKeys(aks)
+			user := &models.User{Claims: &models.JWTUser{ID: 1, Kind: tt.kind}}
+			user.ID = 1
+
+			body := url.Values{"name": {"deploy"}, "expires_in": {tt.expiresIn}}.Encode()
+			r := httptest.NewRequest("POST", "/api-keys", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r = r.WithContext(context.WithUser(r.Context(), user))
+			w := httptest.NewRecorder()
+			ak.Create(w, r)
+
+			if created := len(aks.created) == 1; created != tt.wantCreated {
+				t.Fatalf("created = %v; want %v", created, tt.wantCreated)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantCreated && got["error"] != nil {
+				t.Errorf("Error = %v", got["error"])
+			}
+			if !tt.wantCreated && got["error"] == nil {
+				t.Error("expected an error")
+			}
+			if tt.expiresIn != "" && tt.wantCreated && aks.created[0].ExpiresAt == nil {
+				t.Error("ExpiresAt was not set")
+			}
+		})
+	}
+}
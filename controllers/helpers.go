@@ -1,9 +1,11 @@
 package controllers
 
 import (
+	"net"
 	"net/http"
 	"github.com/gorilla/schema"
 	"net/url"
+	"golang-jwt-api/models"
 )
 
 
@@ -30,4 +32,13 @@ func parseValues(values url.Values, dst interface{}) error {
 	return nil
 }
 
-
+// forDevice describes the device a request was made from for
+// the session started by it. The IP address is that of the
+// connection; proxy headers are not trusted.
+func forDevice(r *http.Request) models.TokenOption {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return models.ForDevice(r.UserAgent(), ip)
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"golang-jwt-api/hash"
+	"golang-jwt-api/models"
+	"golang-jwt-api/views"
+)
+
+type Introspection struct {
+	us      models.UserService
+	clients map[string]string
+}
+
+// NewIntrospection creates the introspection controller. clients
+// maps the IDs of the clients allowed to introspect tokens to
+// their secrets.
+func NewIntrospection(us models.UserService, clients map[string]string) *Introspection {
+	return &Introspection{
+		us:      us,
+		clients: clients,
+	}
+}
+
+type IntrospectForm struct {
+	Token         string `schema:"token"`
+	TokenTypeHint string `schema:"token_type_hint"`
+}
+
+// IntrospectionResponse is the response defined by RFC 7662
+// section 2.2. Only "active" is set for inactive tokens.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Nbf       int64  `json:"nbf,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// Introspect tells clients whether a token is currently
+// active, following RFC 7662. Clients authenticate with HTTP
+// Basic authentication or the client_id and client_secret
+// form fields. Invalid, expired and revoked tokens result in
+// {"active": false} rather than an error.
+//
+// POST /introspect
+func (i *Introspection) Introspect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !i.authenticateClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		views.RenderJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+		return
+	}
+
+	var form IntrospectForm
+	if err := parseForm(r, &form); err != nil || form.Token == "" {
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+		return
+	}
+
+	claims, user, err := i.us.Introspect(form.Token)
+	if err != nil {
+		views.RenderJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+	views.RenderJSON(w, http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  user.Username,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt,
+		Iat:       claims.IssuedAt,
+		Nbf:       claims.NotBefore,
+		Sub:       strconv.FormatUint(uint64(claims.ID), 10),
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.Id,
+	})
+}
+
+// authenticateClient checks the client credentials of the
+// request against the configured clients.
+func (i *Introspection) authenticateClient(r *http.Request) bool {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id = r.PostFormValue("client_id")
+		secret = r.PostFormValue("client_secret")
+	}
+	expected, found := i.clients[id]
+	if !found || id == "" || expected == "" {
+		return false
+	}
+	return hash.Equal(secret, expected)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang-jwt-api/models"
+)
+
+// fakeUserService implements the parts of models.UserService
+// the controllers under test use. Calling any other method
+// panics.
+type fakeUserService struct {
+	models.UserService
+	tokens map[string]*models.JWTUser
+	users  map[uint]*models.User
+}
+
+func (f *fakeUserService) Introspect(token string) (*models.JWTUser, *models.User, error) {
+	claims, ok := f.tokens[token]
+	if !ok {
+		return nil, nil, models.ErrWrongToken
+	}
+	return claims, f.users[claims.ID], nil
+}
+
+func newFakeUserService() *fakeUserService {
+	claims := &models.JWTUser{ID: 1}
+	claims.Subject = "1"
+	claims.Issuer = "famistar"
+	claims.ExpiresAt = 2000000000
+	claims.IssuedAt = 1000000000
+	user := &models.User{Username: "test"}
+	user.ID = 1
+	return &fakeUserService{
+		tokens: map[string]*models.JWTUser{"valid": claims},
+		users:  map[uint]*models.User{1: user},
+	}
+}
+
+func TestIntrospection_Introspect(t *testing.T) {
+	ic := NewIntrospection(newFakeUserService(), map[string]string{"legacy": "secret", "blank": ""})
+	tests := []struct {
+		name       string
+		user, pass string
+		token      string
+		wantStatus int
+		wantActive bool
+	}{
+		{"Active token", "legacy", "secret", "valid", http.StatusOK, true},
+		{"Invalid token", "legacy", "secret", "invalid", http.StatusOK, false},
+		{"Missing token", "legacy", "secret", "", http.StatusBadRequest, false},
+		{"Wrong client secret", "legacy", "wrong", "valid", http.StatusUnauthorized, false},
+		{"Unknown client", "other", "secret", "valid", http.StatusUnauthorized, false},
+		{"Empty client secret", "blank", "", "valid", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := url.Values{"token": {tt.token}}.Encode()
+			r := httptest.NewRequest("POST", "/introspect", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth(tt.user, tt.pass)
+			w := httptest.NewRecorder()
+			ic.Introspect(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["active"] != tt.wantActive {
+				t.Errorf("active = %v; want %v", got["active"], tt.wantActive)
+			}
+			if !tt.wantActive && len(got) != 1 {
+				t.Errorf("inactive response = %v; want only the active member", got)
+			}
+			if tt.wantActive && (got["sub"] != "1" || got["username"] != "test" || got["exp"] != float64(2000000000)) {
+				t.Errorf("active response = %v; want sub, username and exp of the token", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+	"golang-jwt-api/views"
+)
+
+type OAuth struct {
+	us models.UserService
+	os models.OAuthService
+	// idTokens issues ID tokens for the openid scope, when the
+	// OpenID Connect endpoints are mounted.
+	idTokens bool
+}
+
+func NewOAuth(us models.UserService, os models.OAuthService, idTokens bool) *OAuth {
+	return &OAuth{
+		us:       us,
+		os:       os,
+		idTokens: idTokens,
+	}
+}
+
+// oauthError is an error response defined by RFC 6749.
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type ClientForm struct {
+	Name         string   `schema:"name"`
+	RedirectURIs []string `schema:"redirect_uri"`
+	Confidential bool     `schema:"confidential"`
+	// ServiceAccount registers a client for the client
+	// credentials grant, allowed to request Scope.
+	ServiceAccount bool   `schema:"service_account"`
+	Scope          string `schema:"scope"`
+}
+
+// RegisterClient registers a third-party application owned
+// by the current user. The client secret of confidential
+// clients is only returned in this response.
+//
+// POST /oauth/clients
+func (o *OAuth) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	var form ClientForm
+	var vd views.Data
+	if err := parseForm(r, &form); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+
+	client := models.OAuthClient{
+		Name:           form.Name,
+		RedirectURIs:   strings.Join(form.RedirectURIs, " "),
+		OwnerID:        context.User(r.Context()).ID,
+		ServiceAccount: form.ServiceAccount,
+		Scope:          form.Scope,
+	}
+	if err := o.os.RegisterClient(&client, form.Confidential); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, client)
+}
+
+type AuthorizeForm struct {
+	ResponseType        string `schema:"response_type"`
+	ClientID            string `schema:"client_id"`
+	RedirectURI         string `schema:"redirect_uri"`
+	Scope               string `schema:"scope"`
+	State               string `schema:"state"`
+	CodeChallenge       string `schema:"code_challenge"`
+	CodeChallengeMethod string `schema:"code_challenge_method"`
+	Nonce               string `schema:"nonce"`
+	Approve             bool   `schema:"approve"`
+}
+
+// Consent is returned by GET /authorize so the frontend can
+// ask the user whether to grant the client access.
+type Consent struct {
+	ClientID    string `json:"client_id"`
+	ClientName  string `json:"client_name"`
+	Scope       string `json:"scope,omitempty"`
+	RedirectURI string `json:"redirect_uri"`
+}
+
+// AuthorizeResponse tells the frontend where to send the user
+// after the consent decision.
+type AuthorizeResponse struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// authorizeRequest validates an authorization request. Errors
+// about the client or redirect URI are returned as err and
+// must be shown to the user, since redirecting could send the
+// user to an attacker. Other errors are returned as the error
+// code to redirect back to the client with.
+func (o *OAuth) authorizeRequest(form AuthorizeForm) (client *models.OAuthClient, redirectURI string, oauthErr string, err error) {
+	client, err = o.os.ClientByID(form.ClientID)
+	if err == models.ErrNotFound {
+		return nil, "", "", models.ErrClientUnknown
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	redirectURI, err = o.os.RedirectURI(client, form.RedirectURI)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if form.ResponseType != "code" {
+		return client, redirectURI, "unsupported_response_type", nil
+	}
+	if form.CodeChallenge == "" || form.CodeChallengeMethod != models.CodeChallengeS256 {
+		return client, redirectURI, "invalid_request", nil
+	}
+	return client, redirectURI, "", nil
+}
+
+// ConsentForm validates an authorization request and returns
+// what the user is asked to consent to.
+//
+// GET /authorize
+func (o *OAuth) ConsentForm(w http.ResponseWriter, r *http.Request) {
+	var form AuthorizeForm
+	var vd views.Data
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+
+	client, redirectURI, oauthErr, err := o.authorizeRequest(form)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	if oauthErr != "" {
+		views.Render(w, r, AuthorizeResponse{
+			RedirectTo: redirectWith(redirectURI, url.Values{"error": {oauthErr}}, form.State),
+		})
+		return
+	}
+	views.Render(w, r, Consent{
+		ClientID:    client.ClientID,
+		ClientName:  client.Name,
+		Scope:       form.Scope,
+		RedirectURI: redirectURI,
+	})
+}
+
+// Authorize records the consent decision of the current user
+// and returns where to redirect the user: back to the client
+// with an authorization code, or with an error if access was
+// denied.
+//
+// POST /authorize
+func (o *OAuth) Authorize(w http.ResponseWriter, r *http.Request) {
+	var form AuthorizeForm
+	var vd views.Data
+	parseURLParams(r, &form)
+	if err := parseForm(r, &form); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+
+	client, redirectURI, oauthErr, err := o.authorizeRequest(form)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	if oauthErr == "" && !form.Approve {
+		oauthErr = "access_denied"
+	}
+	if oauthErr != "" {
+		views.Render(w, r, AuthorizeResponse{
+			RedirectTo: redirectWith(redirectURI, url.Values{"error": {oauthErr}}, form.State),
+		})
+		return
+	}
+
+	user := context.User(r.Context())
+	code, err := o.os.CreateAuthorizationCode(models.AuthorizationRequest{
+		Client:              client,
+		UserID:              user.ID,
+		RedirectURI:         form.RedirectURI,
+		Scope:               form.Scope,
+		CodeChallenge:       form.CodeChallenge,
+		CodeChallengeMethod: form.CodeChallengeMethod,
+		Nonce:               form.Nonce,
+		AuthTime:            authTime(user),
+	})
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, AuthorizeResponse{
+		RedirectTo: redirectWith(redirectURI, url.Values{"code": {code}}, form.State),
+	})
+}
+
+// authTime returns when the user last entered their password,
+// which is when the token they are signed in with was issued.
+func authTime(user *models.User) time.Time {
+	if user.Claims != nil && user.Claims.IssuedAt != 0 {
+		return time.Unix(user.Claims.IssuedAt, 0)
+	}
+	return time.Now()
+}
+
+// redirectWith adds params and the state, if any, to the
+// query of the redirect URI.
+func redirectWith(redirectURI string, params url.Values, state string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+type TokenForm struct {
+	GrantType    string `schema:"grant_type"`
+	Code         string `schema:"code"`
+	RedirectURI  string `schema:"redirect_uri"`
+	ClientID     string `schema:"client_id"`
+	ClientSecret string `schema:"client_secret"`
+	CodeVerifier string `schema:"code_verifier"`
+	Scope        string `schema:"scope"`
+}
+
+// TokenResponse is the successful response of the token
+// endpoint defined by RFC 6749 section 5.1.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+}
+
+// Token issues tokens for the authorization code and client
+// credentials grants.
+//
+// POST /token
+func (o *OAuth) Token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	var form TokenForm
+	if err := parseForm(r, &form); err != nil {
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request"})
+		return
+	}
+
+	client, err := o.authenticateClient(r, form)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		views.RenderJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+		return
+	}
+
+	switch form.GrantType {
+	case "authorization_code":
+		o.authorizationCodeGrant(w, client, form)
+	case "client_credentials":
+		o.clientCredentialsGrant(w, client, form)
+	default:
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "unsupported_grant_type"})
+	}
+}
+
+// authorizationCodeGrant exchanges an authorization code for
+// an OAuth token, which only grants the approved scope. The
+// token starts a session listed under the client's name, so the
+// user can revoke it. If the "openid" scope was granted, an
+// OpenID Connect ID token is returned as well.
+func (o *OAuth) authorizationCodeGrant(w http.ResponseWriter, client *models.OAuthClient, form TokenForm) {
+	code, err := o.os.ExchangeAuthorizationCode(client, form.Code, form.RedirectURI, form.CodeVerifier)
+	if err == models.ErrAuthorizationCodeInvalid {
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_grant"})
+		return
+	}
+	if err != nil {
+		views.RenderJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+		return
+	}
+
+	user, err := o.us.ByID(code.UserID)
+	if err != nil {
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_grant"})
+		return
+	}
+	err = o.us.StartSession(user,
+		models.OfKind(models.OAuthToken),
+		models.ForClient(client.ClientID),
+		models.ForScope(code.Scope),
+		models.ForDevice(client.Name, ""))
+	if err != nil {
+		views.RenderJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+		return
+	}
+	res := TokenResponse{
+		AccessToken: user.Token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(user.TokenExpiresAt) / time.Second),
+		Scope:       code.Scope,
+	}
+	if o.idTokens && models.HasScope(code.Scope, models.ScopeOpenID) {
+		res.IDToken, err = o.us.GenerateIDToken(user, client.ClientID, code.Nonce, code.AuthTime)
+		if err != nil {
+			views.RenderJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+			return
+		}
+	}
+	views.RenderJSON(w, http.StatusOK, res)
+}
+
+// clientCredentialsGrant issues a service token to a service
+// account authenticated with its own credentials.
+func (o *OAuth) clientCredentialsGrant(w http.ResponseWriter, client *models.OAuthClient, form TokenForm) {
+	scope, err := client.GrantScope(form.Scope)
+	switch err {
+	case nil:
+	case models.ErrNotServiceAccount:
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "unauthorized_client"})
+		return
+	default:
+		views.RenderJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_scope"})
+		return
+	}
+
+	token, expiresAt, err := o.us.GenerateServiceToken(client, scope)
+	if err != nil {
+		views.RenderJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+		return
+	}
+	views.RenderJSON(w, http.StatusOK, TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expiresAt) / time.Second),
+		Scope:       scope,
+	})
+}
+
+// authenticateClient identifies the client of a token request.
+// Confidential clients must authenticate with HTTP Basic
+// authentication or the client_secret form field; public
+// clients only send their client_id and rely on PKCE.
+func (o *OAuth) authenticateClient(r *http.Request, form TokenForm) (*models.OAuthClient, error) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return o.os.AuthenticateClient(id, secret)
+	}
+	if form.ClientSecret != "" {
+		return o.os.AuthenticateClient(form.ClientID, form.ClientSecret)
+	}
+	client, err := o.os.ClientByID(form.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Confidential() {
+		return nil, models.ErrClientAuthFailed
+	}
+	return client, nil
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+	"golang-jwt-api/views"
+)
+
+// OpenID Connect scopes selecting which claims the userinfo
+// endpoint returns.
+const (
+	scopeEmail   = "email"
+	scopeProfile = "profile"
+)
+
+type OIDC struct {
+	us models.UserService
+}
+
+func NewOIDC(us models.UserService) *OIDC {
+	return &OIDC{
+		us: us,
+	}
+}
+
+// Discovery is the provider metadata defined by OpenID Connect
+// Discovery 1.0.
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// Discovery describes the provider. The endpoints are derived
+// from the issuer, which must be the URL the service is
+// reachable at for relying parties to accept its ID tokens.
+//
+// GET /.well-known/openid-configuration
+func (o *OIDC) Discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := o.us.Issuer()
+	base := strings.TrimSuffix(issuer, "/")
+	views.RenderJSON(w, http.StatusOK, Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             base + "/authorize",
+		TokenEndpoint:                     base + "/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		ScopesSupported:                   []string{models.ScopeOpenID, scopeEmail, scopeProfile},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "preferred_username"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{models.CodeChallengeS256},
+	})
+}
+
+// JWK is a public RSA key as defined by RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes the key ID tokens and access tokens are
+// signed with, and the replaced keys still verifying tokens
+// signed before a rotation.
+//
+// GET /.well-known/jwks.json
+func (o *OIDC) JWKS(w http.ResponseWriter, r *http.Request) {
+	var jwks JWKS
+	for _, pub := range o.us.PublicKeys() {
+		jwks.Keys = append(jwks.Keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: models.KeyID(pub),
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		})
+	}
+	views.RenderJSON(w, http.StatusOK, jwks)
+}
+
+// UserInfo holds the standard claims returned by the userinfo
+// endpoint.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// UserInfo returns the claims about the current user that the
+// access token grants. Tokens issued by /login carry no scope
+// and get every claim; tokens issued to clients must carry the
+// "openid" scope, and "email" and "profile" select the claims.
+//
+// GET, POST /userinfo
+func (o *OIDC) UserInfo(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	scope := ""
+	if user.Claims != nil {
+		scope = user.Claims.Scope
+	}
+	if scope != "" && !models.HasScope(scope, models.ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		views.RenderJSON(w, http.StatusForbidden, oauthError{Error: "insufficient_scope"})
+		return
+	}
+
+	// Users authenticated statelessly only carry their claims.
+	user, err := o.us.ByID(user.ID)
+	if err != nil {
+		views.RenderJSON(w, http.StatusInternalServerError, oauthError{Error: "server_error"})
+		return
+	}
+	info := UserInfo{Subject: strconv.FormatUint(uint64(user.ID), 10)}
+	if scope == "" || models.HasScope(scope, scopeEmail) {
+		info.Email = user.Email
+		info.EmailVerified = &user.EmailVerified
+	}
+	if scope == "" || models.HasScope(scope, scopeProfile) {
+		info.PreferredUsername = user.Username
+	}
+	views.RenderJSON(w, http.StatusOK, info)
+}
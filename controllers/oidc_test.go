@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+)
+
+type fakeOIDCUserService struct {
+	*fakeUserService
+	pub      *rsa.PublicKey
+	previous []*rsa.PublicKey
+}
+
+func (f *fakeOIDCUserService) ByID(id uint) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeOIDCUserService) PublicKey() *rsa.PublicKey { return f.pub }
+
+func (f *fakeOIDCUserService) PublicKeys() []*rsa.PublicKey {
+	return append([]*rsa.PublicKey{f.pub}, f.previous...)
+}
+
+func (f *fakeOIDCUserService) Issuer() string { return "https://auth.example.com/" }
+
+func newFakeOIDCUserService(t *testing.T) *fakeOIDCUserService {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := newFakeUserService()
+	us.users[1].Email = "test@test.com"
+	return &fakeOIDCUserService{fakeUserService: us, pub: &key.PublicKey}
+}
+
+func TestOIDC_Discovery(t *testing.T) {
+	oc := NewOIDC(newFakeOIDCUserService(t))
+	w := httptest.NewRecorder()
+	oc.Discovery(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+
+	var got Discovery
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Issuer != "https://auth.example.com/" {
+		t.Errorf("issuer = %q", got.Issuer)
+	}
+	if got.JWKSURI != "https://auth.example.com/.well-known/jwks.json" {
+		t.Errorf("jwks_uri = %q", got.JWKSURI)
+	}
+	if got.TokenEndpoint != "https://auth.example.com/token" {
+		t.Errorf("token_endpoint = %q", got.TokenEndpoint)
+	}
+}
+
+func TestOIDC_JWKS(t *testing.T) {
+	us := newFakeOIDCUserService(t)
+	oc := NewOIDC(us)
+	w := httptest.NewRecorder()
+	oc.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	var got JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Keys) != 1 {
+		t.Fatalf("len(keys) = %d; want 1", len(got.Keys))
+	}
+	if got.Keys[0].Kid != models.KeyID(us.pub) || got.Keys[0].E != "AQAB" {
+		t.Errorf("key = %+v", got.Keys[0])
+	}
+}
+
+func TestOIDC_JWKS_PreviousKeys(t *testing.T) {
+	us := newFakeOIDCUserService(t)
+	previous, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us.previous = []*rsa.PublicKey{&previous.PublicKey}
+	oc := NewOIDC(us)
+	w := httptest.NewRecorder()
+	oc.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	var got JWKS
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Keys) != 2 || got.Keys[0].Kid != models.KeyID(us.pub) || got.Keys[1].Kid != models.KeyID(&previous.PublicKey) {
+		t.Errorf("keys = %+v; want the current key, then the previous one", got.Keys)
+	}
+}
+
+func TestOIDC_UserInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		scope        string
+		wantStatus   int
+		wantEmail    bool
+		wantUsername bool
+	}{
+		{"First-party token", "", http.StatusOK, true, true},
+		{"Email scope", "openid email", http.StatusOK, true, false},
+		{"Profile scope", "openid profile", http.StatusOK, false, true},
+		{"Missing openid scope", "email", http.StatusForbidden, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oc := NewOIDC(newFakeOIDCUserService(t))
+			user := &models.User{Claims: &models.JWTUser{ID: 1, Scope: tt.scope}}
+			user.ID = 1
+			r := httptest.NewRequest("GET", "/userinfo", nil)
+			r = r.WithContext(context.WithUser(r.Context(), user))
+			w := httptest.NewRecorder()
+			oc.UserInfo(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["sub"] != "1" {
+				t.Errorf("sub = %v; want 1", got["sub"])
+			}
+			if _, ok := got["email"]; ok != tt.wantEmail {
+				t.Errorf("email present = %v; want %v", ok, tt.wantEmail)
+			}
+			if verified, ok := got["email_verified"]; ok != tt.wantEmail || (ok && verified != false) {
+				t.Errorf("email_verified = %v, present %v; want false along with the email", verified, ok)
+			}
+			if _, ok := got["preferred_username"]; ok != tt.wantUsername {
+				t.Errorf("preferred_username present = %v; want %v", ok, tt.wantUsername)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+	"golang-jwt-api/views"
+)
+
+// Sessions lists the devices the current user is signed in
+// on, marking the one this request was made from.
+//
+// GET /sessions
+func (u *Users) Sessions(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	sessions, err := u.us.Sessions(user.ID)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	if user.Claims != nil && user.Claims.Id != "" {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].TokenID == user.Claims.Id
+		}
+	}
+	views.Render(w, r, sessions)
+}
+
+// RevokeSession signs the current user out of one device.
+//
+// DELETE /sessions/{id}
+func (u *Users) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		vd.SetError(models.ErrIDInvalid)
+		views.Render(w, r, vd)
+		return
+	}
+	if err := u.us.RevokeSession(context.User(r.Context()).ID, uint(id)); err != nil {
+		vd.SetError(err)
+		views.Render(w, r, vd)
+		return
+	}
+	views.Render(w, r, vd)
+}
@@ -2,24 +2,35 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
+	"github.com/gorilla/mux"
 	"golang-jwt-api/models"
 	"golang-jwt-api/views"
 	"golang-jwt-api/context"
+	"golang-jwt-api/cookies"
 )
 
 type Users struct {
 	us        models.UserService
+	cookie    *cookies.TokenCookie
 }
 
-func NewUsers(us models.UserService) *Users {
+// NewUsers creates the users controller. If cookie is nil,
+// tokens are only returned in the response body.
+func NewUsers(us models.UserService, cookie *cookies.TokenCookie) *Users {
 	return &Users{
 		us:        us,
+		cookie:    cookie,
 	}
 }
 
 type LoginForm struct {
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	Audience string `schema:"audience"`
+	// Cookie asks for the token to be set in an HttpOnly
+	// cookie instead of being returned in the body.
+	Cookie   bool   `schema:"cookie"`
 }
 
 // Login is used to verify the provided email address and
@@ -36,12 +47,21 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	user, err := u.us.Authenticate(form.Email, form.Password,
+		models.ForAudience(form.Audience),
+		forDevice(r))
 	if err != nil {
 		vd.SetError(err)
 		views.Render(w,r,vd)
 		return
 	}
+	if form.Cookie {
+		if err := u.setCookie(w, user); err != nil {
+			vd.SetError(err)
+			views.Render(w,r,vd)
+			return
+		}
+	}
 
 	views.Render(w,r,user)
 }
@@ -50,6 +70,10 @@ type SignupForm struct {
 	Username string `schema:"username"`
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+	Audience string `schema:"audience"`
+	// Cookie asks for the token to be set in an HttpOnly
+	// cookie instead of being returned in the body.
+	Cookie   bool   `schema:"cookie"`
 }
 
 
@@ -73,11 +97,21 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		Password: form.Password,
 	}
 
-	if err := u.us.CreateUserWithToken(&user); err != nil {
+	err := u.us.CreateUserWithToken(&user,
+		models.ForAudience(form.Audience),
+		forDevice(r))
+	if err != nil {
 		vd.SetError(err)
 		views.Render(w,r,vd)
 		return
 	}
+	if form.Cookie {
+		if err := u.setCookie(w, &user); err != nil {
+			vd.SetError(err)
+			views.Render(w,r,vd)
+			return
+		}
+	}
 	views.Render(w,r,user)
 }
 
@@ -94,7 +128,12 @@ func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	var form ChangePasswordForm
 	var vd views.Data
 	parseURLParams(r, &form)
-	user := context.User(r.Context())
+	user, err := u.currentUser(r)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
 
 	if err := parseForm(r, &form); err != nil {
 		vd.SetError(err)
@@ -102,20 +141,155 @@ func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	foundUser, err := u.us.ChangePassword(user, form.CurrentPassword, form.NewPassword, form.RepeatedPassword);
+	foundUser, err := u.us.ChangePassword(user, form.CurrentPassword, form.NewPassword, form.RepeatedPassword, forDevice(r))
 	if  err != nil {
 		vd.SetError(err)
 		views.Render(w,r,vd)
 		return
 	}
+	if u.fromCookie(r) {
+		if err := u.setCookie(w, foundUser); err != nil {
+			vd.SetError(err)
+			views.Render(w,r,vd)
+			return
+		}
+	}
 	views.Render(w,r,foundUser)
 }
 
+// currentUser loads the full record of the user set in the
+// request context. Handlers that modify the user must use it,
+// since the user in the context only holds the token claims
+// when the User middleware runs in stateless mode.
+func (u *Users) currentUser(r *http.Request) (*models.User, error) {
+	user := context.User(r.Context())
+	return u.us.ByID(user.ID)
+}
+
 // Get the current user
 //
 // GET /user
 func (u *Users) GetUser(w http.ResponseWriter, r *http.Request) {
-	user := context.User(r.Context())
+	var vd views.Data
+	user, err := u.currentUser(r)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
 	views.Render(w,r,user)
 }
 
+// Show returns a user to service accounts granted the
+// users:read scope. Users can only look up themselves.
+//
+// GET /users/{id}
+func (u *Users) Show(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		vd.SetError(models.ErrIDInvalid)
+		views.Render(w,r,vd)
+		return
+	}
+	if current := context.User(r.Context()); current != nil && current.ID != uint(id) {
+		vd.SetError(models.ErrNotFound)
+		views.Render(w,r,vd)
+		return
+	}
+	user, err := u.us.ByID(uint(id))
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
+	views.Render(w,r,user)
+}
+
+// SignOutEverywhere revokes every token issued to the current
+// user, including the one used for this request.
+//
+// POST /logout-all
+func (u *Users) SignOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user, err := u.currentUser(r)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
+	if err := u.us.RevokeTokens(user); err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
+	if u.cookie != nil {
+		u.cookie.Clear(w)
+	}
+	views.Render(w,r,user)
+}
+
+// Logout ends the session of the token used for this request
+// and clears the token cookie.
+//
+// POST /logout
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	if user.Claims != nil && user.Claims.Id != "" {
+		if err := u.us.RevokeSessionByTokenID(user.ID, user.Claims.Id); err != nil {
+			vd.SetError(err)
+			views.Render(w,r,vd)
+			return
+		}
+	}
+	if u.cookie != nil {
+		u.cookie.Clear(w)
+	}
+	views.Render(w,r,vd)
+}
+
+// setCookie moves the token of the user into the token cookie,
+// so it is not readable by JavaScript. Without cookie mode the
+// token stays in the body.
+func (u *Users) setCookie(w http.ResponseWriter, user *models.User) error {
+	if u.cookie == nil {
+		return nil
+	}
+	if err := u.cookie.Set(w, user.Token, user.TokenExpiresAt); err != nil {
+		return err
+	}
+	user.Token = ""
+	return nil
+}
+
+// fromCookie reports whether the request was authenticated by
+// the token cookie.
+func (u *Users) fromCookie(r *http.Request) bool {
+	return u.cookie != nil && r.Header.Get("Authorization") == "" && u.cookie.Token(r) != ""
+}
+
+type LoginHistoryForm struct {
+	Limit int `schema:"limit"`
+}
+
+// Logins lists recent successful and failed login attempts to
+// the current user's account.
+//
+// GET /user/logins
+func (u *Users) Logins(w http.ResponseWriter, r *http.Request) {
+	var form LoginHistoryForm
+	var vd views.Data
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
+	attempts, err := u.us.LoginHistory(context.User(r.Context()).ID, form.Limit)
+	if err != nil {
+		vd.SetError(err)
+		views.Render(w,r,vd)
+		return
+	}
+	views.Render(w,r,attempts)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang-jwt-api/context"
+	"golang-jwt-api/models"
+)
+
+func TestUsers_GetUser_Stateless(t *testing.T) {
+	us := newFakeOIDCUserService(t)
+	uc := NewUsers(us, nil)
+	// In stateless mode the user in the context only holds the
+	// token claims.
+	user := &models.User{Claims: &models.JWTUser{ID: 1}}
+	user.ID = 1
+	r := httptest.NewRequest("GET", "/user", nil)
+	r = r.WithContext(context.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	uc.GetUser(w, r)
+
+	var got struct {
+		Result struct {
+			Username string
+			Email    string
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Result.Username != "test" || got.Result.Email != "test@test.com" {
+		t.Errorf("GetUser() rendered %s; want the full user record", w.Body.String())
+	}
+}
+
+func TestUsers_Show(t *testing.T) {
+	self := &models.User{}
+	self.ID = 1
+	other := &models.User{}
+	other.ID = 2
+	tests := []struct {
+		name    string
+		user    *models.User
+		service *models.ServicePrincipal
+		wantErr bool
+	}{
+		{"Service account", nil, &models.ServicePrincipal{ClientID: "cron", Scope: models.ScopeReadUsers}, false},
+		{"The user themselves", self, nil, false},
+		{"Another user", other, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := NewUsers(newFakeOIDCUserService(t), nil)
+			r := httptest.NewRequest("GET", "/users/1", nil)
+			r = mux.SetURLVars(r, map[string]string{"id": "1"})
+			if tt.user != nil {
+				r = r.WithContext(context.WithUser(r.Context(), tt.user))
+			}
+			if tt.service != nil {
+				r = r.WithContext(context.WithService(r.Context(), tt.service))
+			}
+			w := httptest.NewRecorder()
+			uc.Show(w, r)
+
+			var got struct {
+				Error  *struct{ Message string } `json:"error"`
+				Result struct{ Username string } `json:"result"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if (got.Error != nil) != tt.wantErr || !tt.wantErr && got.Result.Username != "test" {
+				t.Errorf("Show() rendered %s; want error %v", w.Body.String(), tt.wantErr)
+			}
+		})
+	}
+}
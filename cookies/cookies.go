@@ -0,0 +1,108 @@
+// Package cookies keeps the access token of browser clients
+// in an HttpOnly cookie instead of letting JavaScript store it,
+// and protects requests authenticated by it with a
+// double-submit CSRF token.
+package cookies
+
+import (
+	"net/http"
+	"time"
+
+	"golang-jwt-api/hash"
+	"golang-jwt-api/rand"
+)
+
+const (
+	// CSRFHeader carries the value of the CSRF cookie on
+	// state-changing requests.
+	CSRFHeader = "X-CSRF-Token"
+
+	csrfTokenBytes = 32
+)
+
+// TokenCookie describes the cookies holding the access token
+// and the CSRF token.
+type TokenCookie struct {
+	// Name is the name of the HttpOnly token cookie, and
+	// CSRFName that of the CSRF cookie readable by JavaScript.
+	Name     string
+	CSRFName string
+	Domain   string
+	SameSite http.SameSite
+	// Secure should only be turned off for local development
+	// over plain HTTP.
+	Secure bool
+}
+
+// New returns a TokenCookie named "token" with the CSRF cookie
+// named "csrf_token", sent over HTTPS only and with SameSite
+// set to Lax.
+func New() *TokenCookie {
+	return &TokenCookie{
+		Name:     "token",
+		CSRFName: "csrf_token",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+	}
+}
+
+// Set stores the token in its cookie along with a new CSRF
+// token. Both expire with the token.
+func (c *TokenCookie) Set(w http.ResponseWriter, token string, expires time.Time) error {
+	csrf, err := rand.String(csrfTokenBytes)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, c.cookie(c.Name, token, expires, true))
+	http.SetCookie(w, c.cookie(c.CSRFName, csrf, expires, false))
+	return nil
+}
+
+// Clear deletes both cookies.
+func (c *TokenCookie) Clear(w http.ResponseWriter) {
+	expired := time.Unix(0, 0)
+	http.SetCookie(w, c.cookie(c.Name, "", expired, true))
+	http.SetCookie(w, c.cookie(c.CSRFName, "", expired, false))
+}
+
+// Token returns the token sent in the cookie, if any.
+func (c *TokenCookie) Token(r *http.Request) string {
+	cookie, err := r.Cookie(c.Name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// VerifyCSRF reports whether a request authenticated by the
+// cookie may proceed. Safe methods always may; other requests
+// must repeat the CSRF cookie in the CSRFHeader header, which
+// other sites cannot do since they cannot read the cookie.
+func (c *TokenCookie) VerifyCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := r.Cookie(c.CSRFName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return hash.Equal(cookie.Value, r.Header.Get(CSRFHeader))
+}
+
+func (c *TokenCookie) cookie(name, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   c.Domain,
+		Expires:  expires,
+		Secure:   c.Secure,
+		HttpOnly: httpOnly,
+		SameSite: c.SameSite,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
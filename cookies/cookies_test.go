@@ -0,0 +1,70 @@
+package cookies
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenCookie_Set(t *testing.T) {
+	c := New()
+	w := httptest.NewRecorder()
+	expires := time.Now().Add(time.Hour)
+	if err := c.Set(w, "jwt", expires); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("got %d cookies; want 2", len(cookies))
+	}
+	token, csrf := cookies[0], cookies[1]
+	if token.Name != "token" || token.Value != "jwt" || !token.HttpOnly || !token.Secure || token.SameSite != http.SameSiteLaxMode {
+		t.Errorf("token cookie = %+v", token)
+	}
+	if csrf.Name != "csrf_token" || csrf.Value == "" || csrf.HttpOnly || !csrf.Secure {
+		t.Errorf("CSRF cookie = %+v", csrf)
+	}
+}
+
+func TestTokenCookie_VerifyCSRF(t *testing.T) {
+	c := New()
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		want   bool
+	}{
+		{"Safe method", "GET", "", "", true},
+		{"Matching header", "POST", "abc", "abc", true},
+		{"Missing header", "POST", "abc", "", false},
+		{"Wrong header", "DELETE", "abc", "abd", false},
+		{"Missing cookie", "POST", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: c.CSRFName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(CSRFHeader, tt.header)
+			}
+			if got := c.VerifyCSRF(r); got != tt.want {
+				t.Errorf("VerifyCSRF() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenCookie_Clear(t *testing.T) {
+	c := New()
+	w := httptest.NewRecorder()
+	c.Clear(w)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 || cookie.Value != "" {
+			t.Errorf("cookie %s not cleared: %+v", cookie.Name, cookie)
+		}
+	}
+}
@@ -3,29 +3,145 @@ package hash
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"hash"
+	"strings"
+	"sync"
 )
 
 // NewHMAC creates and returns a new HMAC object
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
+	keyBytes := []byte(key)
 	return HMAC{
-		hmac: h,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, keyBytes)
+			},
+		},
 	}
 }
 
 // HMAC is a wrapper around the crypto/hmac package making
-// it a little easier to use in our code.
+// it a little easier to use in our code. It is safe for
+// concurrent use: each call to Hash borrows its own
+// hash.Hash from a pool instead of sharing a single one.
 type HMAC struct {
-	hmac hash.Hash
+	pool *sync.Pool
 }
 
 // Hash will hash the provided input string using HMAC with
 // the secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b := h.hmac.Sum(nil)
+	mac := h.pool.Get().(hash.Hash)
+	defer h.pool.Put(mac)
+	mac.Reset()
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 	return base64.URLEncoding.EncodeToString(b)
 }
+
+// Verify reports whether digest is the HMAC of input. The
+// comparison is done in constant time.
+func (h HMAC) Verify(input, digest string) bool {
+	return Equal(h.Hash(input), digest)
+}
+
+// Equal compares two digests in constant time, so the time
+// taken does not reveal how much of them matched.
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// NewKeyedHMAC creates a KeyedHMAC from a set of keys indexed
+// by key ID. Digests are produced with the current key, while
+// every retained key is still accepted by Verify. The key ID
+// "" is reserved for the legacy single key and produces
+// digests without a prefix, identical to those of NewHMAC.
+func NewKeyedHMAC(keys map[string]string, current string) (KeyedHMAC, error) {
+	if _, ok := keys[current]; !ok {
+		return KeyedHMAC{}, ErrUnknownKeyID
+	}
+	kh := KeyedHMAC{
+		current: current,
+		keys:    make(map[string]HMAC, len(keys)),
+	}
+	for id, key := range keys {
+		if strings.Contains(id, keyIDSeparator) {
+			return KeyedHMAC{}, ErrInvalidKeyID
+		}
+		kh.keys[id] = NewHMAC(key)
+	}
+	return kh, nil
+}
+
+const keyIDSeparator = "."
+
+var (
+	// ErrUnknownKeyID is returned when the current key ID is
+	// not one of the provided keys.
+	ErrUnknownKeyID = errors.New("hash: unknown HMAC key ID")
+	// ErrInvalidKeyID is returned when a key ID contains the
+	// separator used to prefix digests.
+	ErrInvalidKeyID = errors.New("hash: HMAC key ID must not contain " + keyIDSeparator)
+)
+
+// KeyedHMAC is a set of HMAC keys that allows the key in use
+// to be rotated without invalidating digests created with
+// previous keys. Digests are prefixed with the ID of the key
+// that created them, e.g. "2024-01.<digest>".
+type KeyedHMAC struct {
+	current string
+	keys    map[string]HMAC
+}
+
+// Hash will hash the provided input string with the current
+// key and prefix the result with its key ID.
+func (kh KeyedHMAC) Hash(input string) string {
+	return kh.hashWith(kh.current, input)
+}
+
+// Digests returns the digest of input under every retained
+// key, starting with the current one. It can be used to look
+// up values stored under any key, such as blind indexes.
+func (kh KeyedHMAC) Digests(input string) []string {
+	digests := []string{kh.Hash(input)}
+	for id := range kh.keys {
+		if id != kh.current {
+			digests = append(digests, kh.hashWith(id, input))
+		}
+	}
+	return digests
+}
+
+// Verify reports whether digest is the HMAC of input under
+// the key its prefix refers to.
+func (kh KeyedHMAC) Verify(input, digest string) bool {
+	id := ""
+	if i := strings.Index(digest, keyIDSeparator); i >= 0 {
+		id = digest[:i]
+	}
+	if _, ok := kh.keys[id]; !ok {
+		return false
+	}
+	return Equal(kh.hashWith(id, input), digest)
+}
+
+// NeedsRehash reports whether digest was created with a key
+// other than the current one.
+func (kh KeyedHMAC) NeedsRehash(digest string) bool {
+	id := ""
+	if i := strings.Index(digest, keyIDSeparator); i >= 0 {
+		id = digest[:i]
+	}
+	return id != kh.current
+}
+
+func (kh KeyedHMAC) hashWith(id, input string) string {
+	digest := kh.keys[id].Hash(input)
+	if id == "" {
+		return digest
+	}
+	return id + keyIDSeparator + digest
+}
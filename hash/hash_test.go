@@ -0,0 +1,108 @@
+package hash
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyedHMAC(t *testing.T) {
+	old, err := NewKeyedHMAC(map[string]string{"": "legacy-key"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeyedHMAC(map[string]string{"": "legacy-key", "k1": "new-key"}, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyDigest := old.Hash("input")
+	if want := NewHMAC("legacy-key").Hash("input"); legacyDigest != want {
+		t.Errorf("Hash() with legacy key = %s; want %s", legacyDigest, want)
+	}
+	digest := rotated.Hash("input")
+	if !strings.HasPrefix(digest, "k1.") {
+		t.Errorf("Hash() = %s; want prefix k1.", digest)
+	}
+
+	testCases := []struct {
+		name   string
+		input  string
+		digest string
+		want   bool
+	}{
+		{"digest from current key", "input", digest, true},
+		{"digest from retained key", "input", legacyDigest, true},
+		{"digest of other input", "other", digest, false},
+		{"digest from unknown key", "input", "k0." + strings.TrimPrefix(digest, "k1."), false},
+		{"tampered digest", "input", digest + "A", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rotated.Verify(tc.input, tc.digest); got != tc.want {
+				t.Errorf("Verify() = %v; want %v", got, tc.want)
+			}
+		})
+	}
+
+	if !rotated.NeedsRehash(legacyDigest) || rotated.NeedsRehash(digest) {
+		t.Errorf("NeedsRehash() should only report digests from retired keys")
+	}
+	if digests := rotated.Digests("input"); len(digests) != 2 || digests[0] != digest {
+		t.Errorf("Digests() = %v; want current digest first out of 2", digests)
+	}
+
+	if _, err := NewKeyedHMAC(map[string]string{"k1": "key"}, "k2"); err != ErrUnknownKeyID {
+		t.Errorf("NewKeyedHMAC() with unknown current key = %v; want %v", err, ErrUnknownKeyID)
+	}
+}
+
+func TestHMACConcurrentUse(t *testing.T) {
+	h := NewHMAC("secret-key")
+	inputs := []string{"alpha", "bravo", "charlie", "delta"}
+	want := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		want[input] = NewHMAC("secret-key").Hash(input)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for g := 0; g < 64; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				input := inputs[(g+i)%len(inputs)]
+				got := h.Hash(input)
+				if got != want[input] || !h.Verify(input, got) {
+					errs <- input
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for input := range errs {
+		t.Errorf("Hash(%s) returned a corrupted digest under concurrent use", input)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want bool
+	}{
+		{"digest", "digest", true},
+		{"digest", "digesT", false},
+		{"digest", "digest-longer", false},
+		{"", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.a+"/"+tc.b, func(t *testing.T) {
+			if got := Equal(tc.a, tc.b); got != tc.want {
+				t.Errorf("Equal(%q, %q) = %v; want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
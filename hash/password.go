@@ -0,0 +1,226 @@
+package hash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrMismatchedHashAndPassword is returned by Compare when
+	// the password does not match the encoded hash.
+	ErrMismatchedHashAndPassword = errors.New("hash: password does not match hash")
+	// ErrUnknownHashFormat is returned when an encoded hash was
+	// not produced by any of the known algorithms.
+	ErrUnknownHashFormat = errors.New("hash: unknown password hash format")
+)
+
+// PasswordHasher hashes passwords into a self-describing
+// string, so the algorithm and parameters used can always be
+// recovered from the stored hash itself.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of the provided password.
+	Hash(password string) (string, error)
+	// Compare returns nil if the password matches the encoded
+	// hash and ErrMismatchedHashAndPassword if it does not.
+	Compare(encoded, password string) error
+	// Identify reports whether the encoded hash was produced
+	// by this algorithm.
+	Identify(encoded string) bool
+	// NeedsRehash reports whether the encoded hash was produced
+	// with an outdated algorithm or parameters.
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswords returns a PasswordHasher that hashes new
+// passwords with current while still accepting hashes
+// produced by any of the legacy hashers.
+func NewPasswords(current PasswordHasher, legacy ...PasswordHasher) PasswordHasher {
+	return &passwords{
+		current: current,
+		hashers: append([]PasswordHasher{current}, legacy...),
+	}
+}
+
+type passwords struct {
+	current PasswordHasher
+	hashers []PasswordHasher
+}
+
+func (p *passwords) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+func (p *passwords) Compare(encoded, password string) error {
+	for _, h := range p.hashers {
+		if h.Identify(encoded) {
+			return h.Compare(encoded, password)
+		}
+	}
+	return ErrUnknownHashFormat
+}
+
+func (p *passwords) Identify(encoded string) bool {
+	for _, h := range p.hashers {
+		if h.Identify(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports true for every hash that was not
+// produced by the current hasher with its current parameters.
+func (p *passwords) NeedsRehash(encoded string) bool {
+	if !p.current.Identify(encoded) {
+		return true
+	}
+	return p.current.NeedsRehash(encoded)
+}
+
+// NewBcrypt creates a bcrypt PasswordHasher with the given
+// cost. A cost of zero uses bcrypt.DefaultCost.
+func NewBcrypt(cost int) PasswordHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return Bcrypt{Cost: cost}
+}
+
+// Bcrypt hashes passwords using bcrypt. Its hashes are
+// already self-describing, e.g. "$2a$10$...".
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedBytes), nil
+}
+
+func (b Bcrypt) Compare(encoded, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatchedHashAndPassword
+	}
+	return err
+}
+
+func (b Bcrypt) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true
+	}
+	return cost != b.Cost
+}
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	argon2SaltLen   = 16
+	argon2KeyLen    = 32
+	argon2idVersion = argon2.Version
+)
+
+// NewArgon2id creates an Argon2id PasswordHasher. Zero values
+// fall back to the parameters recommended by RFC 9106 for
+// memory constrained environments.
+func NewArgon2id(time, memory uint32, threads uint8) PasswordHasher {
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 2
+	}
+	return Argon2id{Time: time, Memory: memory, Threads: threads}
+}
+
+// Argon2id hashes passwords using Argon2id and encodes them
+// in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2idVersion, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Compare(encoded, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatchedHashAndPassword
+	}
+	return nil
+}
+
+func (a Argon2id) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params != a
+}
+
+// decodeArgon2id parses a PHC formatted Argon2id hash into
+// its parameters, salt and derived key.
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	var params Argon2id
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2idVersion {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	return params, salt, key, nil
+}
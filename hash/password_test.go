@@ -0,0 +1,72 @@
+package hash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hasher PasswordHasher
+		prefix string
+	}{
+		{"bcrypt", NewBcrypt(4), "$2a$04$"},
+		{"argon2id", NewArgon2id(1, 1024, 1), "$argon2id$v=19$m=1024,t=1,p=1$"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := tc.hasher.Hash("secret-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encoded, tc.prefix) {
+				t.Errorf("got %s; want prefix %s", encoded, tc.prefix)
+			}
+			if !tc.hasher.Identify(encoded) {
+				t.Errorf("Identify(%s) = false; want true", encoded)
+			}
+			if err := tc.hasher.Compare(encoded, "secret-password"); err != nil {
+				t.Errorf("Compare() with correct password = %v; want nil", err)
+			}
+			if err := tc.hasher.Compare(encoded, "wrong-password"); err != ErrMismatchedHashAndPassword {
+				t.Errorf("Compare() with wrong password = %v; want %v", err, ErrMismatchedHashAndPassword)
+			}
+			if tc.hasher.NeedsRehash(encoded) {
+				t.Errorf("NeedsRehash(%s) = true; want false", encoded)
+			}
+		})
+	}
+}
+
+func TestPasswordsNeedsRehash(t *testing.T) {
+	oldBcrypt, _ := NewBcrypt(4).Hash("secret-password")
+	oldArgon2, _ := NewArgon2id(1, 1024, 1).Hash("secret-password")
+	current := NewArgon2id(2, 1024, 1)
+	currentHash, _ := current.Hash("secret-password")
+	passwords := NewPasswords(current, NewBcrypt(5))
+
+	testCases := []struct {
+		name    string
+		encoded string
+		want    bool
+	}{
+		{"bcrypt hash from a legacy hasher", oldBcrypt, true},
+		{"argon2id hash with outdated parameters", oldArgon2, true},
+		{"argon2id hash with current parameters", currentHash, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := passwords.Compare(tc.encoded, "secret-password"); err != nil {
+				t.Errorf("Compare() = %v; want nil", err)
+			}
+			if got := passwords.NeedsRehash(tc.encoded); got != tc.want {
+				t.Errorf("NeedsRehash() = %v; want %v", got, tc.want)
+			}
+		})
+	}
+
+	if err := passwords.Compare("plaintext", "secret-password"); err != ErrUnknownHashFormat {
+		t.Errorf("Compare() with unknown format = %v; want %v", err, ErrUnknownHashFormat)
+	}
+}
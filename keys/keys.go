@@ -0,0 +1,110 @@
+// Package keys loads the RSA keys tokens are signed and
+// verified with, including passphrase protected PKCS#8 private
+// keys.
+//
+// Tokens are signed through crypto.Signer rather than with an
+// *rsa.PrivateKey directly, so the private key can be kept
+// outside the process, e.g. behind a local agent or a KMS,
+// by any crypto.Signer implementation returning PKCS#1 v1.5
+// RSA signatures.
+package keys
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoPEM is returned when the data holds no PEM block.
+	ErrNoPEM = errors.New("keys: no PEM data found")
+	// ErrPassphraseRequired is returned for encrypted private
+	// keys when no passphrase was given.
+	ErrPassphraseRequired = errors.New("keys: private key is encrypted but no passphrase was given")
+	// ErrNotRSA is returned for keys of other types.
+	ErrNotRSA = errors.New("keys: key is not an RSA key")
+)
+
+// ParsePrivateKeyPEM parses an RSA private key in PKCS#1
+// ("RSA PRIVATE KEY"), PKCS#8 ("PRIVATE KEY") or encrypted
+// PKCS#8 ("ENCRYPTED PRIVATE KEY") PEM form. The passphrase is
+// only used for encrypted keys.
+func ParsePrivateKeyPEM(data []byte, passphrase string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	der := block.Bytes
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		if _, encrypted := block.Headers["Proc-Type"]; encrypted {
+			return nil, ErrUnsupportedEncryption
+		}
+		return x509.ParsePKCS1PrivateKey(der)
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		var err error
+		if der, err = decryptPKCS8(der, []byte(passphrase)); err != nil {
+			return nil, err
+		}
+	case "PRIVATE KEY":
+	default:
+		return nil, fmt.Errorf("keys: unexpected PEM block %q", block.Type)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			return nil, ErrIncorrectPassphrase
+		}
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrNotRSA
+	}
+	return rsaKey, nil
+}
+
+// ParsePublicKeyPEM parses an RSA public key in PKIX ("PUBLIC
+// KEY") or PKCS#1 ("RSA PUBLIC KEY") PEM form, or the public
+// key of a certificate.
+func ParsePublicKeyPEM(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("keys: unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrNotRSA
+	}
+	return rsaKey, nil
+}
+
+// Matches reports whether pub is the public half of priv's key.
+func Matches(priv *rsa.PrivateKey, pub *rsa.PublicKey) bool {
+	return priv.PublicKey.E == pub.E && priv.PublicKey.N.Cmp(pub.N) == 0
+}
@@ -0,0 +1,57 @@
+package keys
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The keys in testdata were generated with openssl for these
+// tests only, e.g.:
+//
+//	openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA256 -passout pass:correct-horse
+const passphrase = "correct-horse"
+
+func readTestdata(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	pub, err := ParsePublicKeyPEM(readTestdata(t, "public.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		file       string
+		passphrase string
+		wantErr    error
+	}{
+		{"PKCS#1", "pkcs1.pem", "", nil},
+		{"PKCS#8", "pkcs8.pem", "", nil},
+		{"PKCS#8 ignores passphrase", "pkcs8.pem", "unused", nil},
+		{"Encrypted AES-256 with SHA-256", "encrypted_aes256_sha256.pem", passphrase, nil},
+		{"Encrypted AES-128 with SHA-1", "encrypted_aes128_sha1.pem", passphrase, nil},
+		{"Encrypted without passphrase", "encrypted_aes256_sha256.pem", "", ErrPassphraseRequired},
+		{"Encrypted with wrong passphrase", "encrypted_aes256_sha256.pem", "battery-staple", ErrIncorrectPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKeyPEM(readTestdata(t, tt.file), tt.passphrase)
+			if err != tt.wantErr {
+				t.Fatalf("ParsePrivateKeyPEM() error = %v; want %v", err, tt.wantErr)
+			}
+			if err == nil && !Matches(key, pub) {
+				t.Error("parsed key does not match the public key")
+			}
+		})
+	}
+
+	if _, err := ParsePrivateKeyPEM([]byte("not pem"), ""); err != ErrNoPEM {
+		t.Errorf("ParsePrivateKeyPEM() of garbage = %v; want ErrNoPEM", err)
+	}
+}
@@ -4,46 +4,189 @@ import (
 	"github.com/gorilla/mux"
 	"net/http"
 	"fmt"
+	"log"
+	"os"
 	"golang-jwt-api/models"
 	"golang-jwt-api/controllers"
 	"golang-jwt-api/middleware"
 	"golang-jwt-api/config"
+	"time"
 )
 
 func main() {
-	cfg := config.LoadConfig()
+	flags, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(flags, os.LookupEnv)
+	if flags.PrintConfig && (err == nil || isValidationError(err)) {
+		must(cfg.Print(os.Stdout))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if flags.PrintConfig {
+		return
+	}
+	if len(flags.Args) > 0 {
+		os.Exit(runCommand(cfg, flags.Args))
+	}
+
+	privateKey, publicKey, err := cfg.GetKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	dbCfg := cfg.Database
+	userCfgs := append([]models.UserServiceConfig{
+		models.WithPasswordHasher(cfg.GetPasswordHasher()),
+		models.WithPeppers(cfg.GetPeppers()),
+		models.WithHMAC(cfg.GetHMAC()),
+		models.WithConcealedAccounts(cfg.Security.ConcealAccounts),
+		models.WithUserCache(cfg.UserCache.Size, cfg.UserCache.TTL.Duration),
+		models.WithLoginNotifier(models.LoginNotifierFunc(logNewDeviceLogin)),
+	}, tokenSettings(cfg, privateKey, publicKey)...)
 	services, err := models.NewServices(
 		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
 		models.WithLogMode(!cfg.IsProd()),
-		models.WithUser(cfg.Pepper, cfg.HMACKey, cfg.GetPublicKey(), cfg.GetPrivateKey()),
+		models.WithOAuth(cfg.GetHMAC(), models.WithServiceAccountPolicy(
+			cfg.OAuth.ServiceAccountOwners, cfg.OAuth.ServiceAccountScopes)),
+		models.WithAPIKey(cfg.GetHMAC()),
+		models.WithUser(cfg.Pepper, cfg.HMACKey, publicKey, privateKey, userCfgs...),
 	)
 	must(err)
 	defer services.Close()
-	services.AutoMigrate()
+	if err := services.Migrate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if retention := cfg.LoginHistory.Retention.Duration; retention > 0 {
+		go pruneLoginHistory(services.User, retention)
+	}
+
+	rl := &reloader{flags: flags, services: services, current: cfg}
+	go rl.run()
 
 	r := mux.NewRouter()
-	usersC := controllers.NewUsers(services.User)
+	tokenCookie := cfg.GetTokenCookie()
+	usersC := controllers.NewUsers(services.User, tokenCookie)
+	introspectionC := controllers.NewIntrospection(services.User, cfg.GetIntrospectionClients())
+	oauthC := controllers.NewOAuth(services.User, services.OAuth, cfg.OIDC.Enabled)
+	oidcC := controllers.NewOIDC(services.User)
+	apiKeysC := controllers.NewAPIKeys(services.APIKey)
 
 
 	userMw := middleware.User{
 		UserService: services.User,
+		Stateless: cfg.Jwt.Stateless,
+		APIKeys: services.APIKey,
+		Cookie: tokenCookie,
 	}
 	requireUserMw := middleware.RequireUser{
 		User: userMw,
 	}
+	requireOpenIDMw := middleware.RequireUser{
+		User: userMw,
+		Scope: models.ScopeOpenID,
+	}
+	requireReadUsersMw := middleware.RequirePrincipal{
+		User: userMw,
+		Scope: models.ScopeReadUsers,
+	}
 
 	r.HandleFunc("/login", usersC.Login).Methods("POST")
 	r.HandleFunc("/create", usersC.Create).Methods("POST")
 	r.Handle("/change-password", requireUserMw.ApplyFn(usersC.ChangePassword)).Methods("POST")
 	r.Handle("/user", requireUserMw.ApplyFn(usersC.GetUser)).Methods("GET")
+	r.Handle("/users/{id:[0-9]+}", requireReadUsersMw.ApplyFn(usersC.Show)).Methods("GET")
+	r.Handle("/user/logins", requireUserMw.ApplyFn(usersC.Logins)).Methods("GET")
+	r.Handle("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
+	r.Handle("/logout-all", requireUserMw.ApplyFn(usersC.SignOutEverywhere)).Methods("POST")
+	r.HandleFunc("/introspect", introspectionC.Introspect).Methods("POST")
+	r.Handle("/oauth/clients", requireUserMw.ApplyFn(oauthC.RegisterClient)).Methods("POST")
+	r.Handle("/authorize", requireUserMw.ApplyFn(oauthC.ConsentForm)).Methods("GET")
+	r.Handle("/authorize", requireUserMw.ApplyFn(oauthC.Authorize)).Methods("POST")
+	r.HandleFunc("/token", oauthC.Token).Methods("POST")
+	if cfg.OIDC.Enabled {
+		r.HandleFunc("/.well-known/openid-configuration", oidcC.Discovery).Methods("GET")
+		r.HandleFunc("/.well-known/jwks.json", oidcC.JWKS).Methods("GET")
+		r.Handle("/userinfo", requireOpenIDMw.ApplyFn(oidcC.UserInfo)).Methods("GET", "POST")
+	}
+	r.Handle("/sessions", requireUserMw.ApplyFn(usersC.Sessions)).Methods("GET")
+	r.Handle("/sessions/{id:[0-9]+}", requireUserMw.ApplyFn(usersC.RevokeSession)).Methods("DELETE")
+	r.Handle("/api-keys", requireUserMw.ApplyFn(apiKeysC.Create)).Methods("POST")
+	r.Handle("/api-keys", requireUserMw.ApplyFn(apiKeysC.Index)).Methods("GET")
+	r.Handle("/api-keys/{id:[0-9]+}", requireUserMw.ApplyFn(apiKeysC.Revoke)).Methods("DELETE")
 
 
 	fmt.Printf("Starting the server on :%d...\n", cfg.Port)
 	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), userMw.Apply(r))
 }
 
+// logNewDeviceLogin logs logins from new devices until an
+// email notifier is available.
+func logNewDeviceLogin(user *models.User, attempt *models.LoginAttempt) error {
+	log.Printf("new device login for user %d from %s (%s)", user.ID, attempt.IP, attempt.UserAgent)
+	return nil
+}
+
+// pruneLoginHistory deletes the login attempts older than
+// retention every hour.
+func pruneLoginHistory(us models.UserService, retention time.Duration) {
+	for ; ; time.Sleep(time.Hour) {
+		deleted, err := us.PruneLoginHistory(time.Now().Add(-retention))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("pruned %d login attempts older than %s", deleted, retention)
+		}
+	}
+}
+
+// issuer returns the configured issuer or the default one.
+func issuer(jwtCfg config.JwtConfig) string {
+	if jwtCfg.Issuer == "" {
+		return models.DefaultIssuer
+	}
+	return jwtCfg.Issuer
+}
+
+// tokenLifetimes converts the configured token lifetimes and
+// their per client overrides.
+func tokenLifetimes(jwtCfg config.JwtConfig) (models.TokenLifetimes, map[string]models.TokenLifetimes) {
+	convert := func(c config.TokenLifetimesConfig) models.TokenLifetimes {
+		return models.TokenLifetimes{
+			Access:       c.Access.Duration,
+			MFAChallenge: c.MFAChallenge.Duration,
+			EmailLink:    c.EmailLink.Duration,
+			Service:      c.Service.Duration,
+		}
+	}
+	clients := make(map[string]models.TokenLifetimes, len(jwtCfg.Clients))
+	for id, c := range jwtCfg.Clients {
+		clients[id] = convert(c)
+	}
+	return convert(jwtCfg.Lifetimes), clients
+}
+
+// revocationCacheTTL returns the configured revocation cache
+// TTL, defaulting to 30 seconds.
+func revocationCacheTTL(jwtCfg config.JwtConfig) time.Duration {
+	if jwtCfg.RevocationCacheTTL.Duration == 0 {
+		return 30 * time.Second
+	}
+	return jwtCfg.RevocationCacheTTL.Duration
+}
+
+// isValidationError reports whether the configuration was
+// loaded but is invalid, in which case it can still be printed.
+func isValidationError(err error) bool {
+	_, ok := err.(*config.ValidationError)
+	return ok
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)
@@ -5,11 +5,23 @@ import (
 	"golang-jwt-api/models"
 	"golang-jwt-api/context"
 	"golang-jwt-api/views"
+	"golang-jwt-api/cookies"
 	"strings"
 )
 
 type User struct {
 	models.UserService
+	// Stateless makes the middleware trust the signed claims
+	// of the token instead of loading the user from the
+	// database on every request. See UserService.ByClaims.
+	Stateless bool
+	// APIKeys, if set, lets users authenticate with an API key
+	// in the X-API-Key header or as a Bearer token.
+	APIKeys models.APIKeyService
+	// Cookie, if set, lets browser clients authenticate with
+	// the token cookie set at login. Requests that change state
+	// must also pass the CSRF check.
+	Cookie *cookies.TokenCookie
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -18,29 +30,107 @@ func (mw *User) Apply(next http.Handler) http.HandlerFunc {
 
 func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			mw.applyAPIKey(w, r, next, apiKey)
+			return
+		}
 		bearer := r.Header.Get("Authorization")
 		if len(bearer) > 7 && strings.ToUpper(bearer[0:6]) == "BEARER" {
 			tokenStr := bearer[7:]
-			user, err := mw.UserService.ByToken(tokenStr)
-			if err != nil {
-				next(w, r)
+			if strings.HasPrefix(tokenStr, models.APIKeyPrefix) {
+				mw.applyAPIKey(w, r, next, tokenStr)
 				return
 			}
-			ctx := r.Context()
-			ctx = context.WithUser(ctx, user)
-			r = r.WithContext(ctx)
-			next(w, r)
-		}else{
-			next(w, r)
+			mw.applyToken(w, r, next, tokenStr)
 			return
 		}
+		if mw.Cookie != nil {
+			if tokenStr := mw.Cookie.Token(r); tokenStr != "" {
+				// Browsers send the cookie along with requests made
+				// by other sites, so the request must prove it was
+				// made by our frontend.
+				if !mw.Cookie.VerifyCSRF(r) {
+					next(w, r)
+					return
+				}
+				mw.applyToken(w, r, next, tokenStr)
+				return
+			}
+		}
+		next(w, r)
 	})
 }
 
+// applyToken authenticates a request made with a JWT as the
+// user or service account it was issued to.
+func (mw *User) applyToken(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, tokenStr string) {
+	var user *models.User
+	var err error
+	if mw.Stateless {
+		user, err = mw.UserService.ByClaims(tokenStr)
+	} else {
+		user, err = mw.UserService.ByToken(tokenStr)
+	}
+	if err == models.ErrTokenKindInvalid {
+		mw.applyService(w, r, next, tokenStr)
+		return
+	}
+	if err != nil {
+		next(w, r)
+		return
+	}
+	ctx := r.Context()
+	ctx = context.WithUser(ctx, user)
+	r = r.WithContext(ctx)
+	next(w, r)
+}
+
+// applyService authenticates a request made with a service
+// token as the service account it was issued to.
+func (mw *User) applyService(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, tokenStr string) {
+	service, err := mw.UserService.ServiceByToken(tokenStr)
+	if err != nil {
+		next(w, r)
+		return
+	}
+	next(w, r.WithContext(context.WithService(r.Context(), service)))
+}
+
+// applyAPIKey authenticates a request made with an API key as
+// the user who created it. The key's scope is exposed through
+// user.Claims like that of a token.
+func (mw *User) applyAPIKey(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, apiKey string) {
+	if mw.APIKeys == nil {
+		next(w, r)
+		return
+	}
+	key, err := mw.APIKeys.Authenticate(apiKey)
+	if err != nil {
+		next(w, r)
+		return
+	}
+	user, err := mw.UserService.ByID(key.UserID)
+	if err != nil || !user.Status.IsActive() {
+		next(w, r)
+		return
+	}
+	user.Claims = &models.JWTUser{
+		ID:      user.ID,
+		Kind:    models.APIKeyToken,
+		Version: user.TokenVersion,
+		Scope:   key.Scope,
+	}
+	next(w, r.WithContext(context.WithUser(r.Context(), user)))
+}
+
 // RequireUser assumes that User middleware has already been run
 // otherwise it will no work correctly.
 type RequireUser struct {
 	User
+	// Scope, if set, also lets through OAuth tokens issued to
+	// third-party clients and API keys that were granted it.
+	// Without it only the user's own tokens are accepted.
+	Scope string
 }
 
 // Apply assumes that User middleware has already been run
@@ -60,7 +150,65 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 			views.Render(w,r, vd)
 			return
 		}
+		if !granted(user.Claims, mw.Scope) {
+			vd.SetError(models.ErrInsufficientScope)
+			views.Render(w,r, vd)
+			return
+		}
 		next(w, r)
 	})
 }
 
+// granted reports whether a user authenticated with claims may
+// use a route requiring scope. OAuth tokens and API keys only
+// grant their scope.
+func granted(claims *models.JWTUser, scope string) bool {
+	if claims == nil {
+		return true
+	}
+	switch claims.Kind {
+	case models.OAuthToken, models.APIKeyToken:
+		return scope != "" && models.HasScope(claims.Scope, scope)
+	}
+	return true
+}
+
+
+// RequirePrincipal lets through requests authenticated as
+// either a user or a service account. It assumes that User
+// middleware has already been run.
+type RequirePrincipal struct {
+	User
+	// Scope is required of service accounts, and of users
+	// authenticated with an OAuth token or API key. Without it
+	// only the user's own tokens are accepted.
+	Scope string
+}
+
+// Apply assumes that User middleware has already been run
+// otherwise it will no work correctly.
+func (mw *RequirePrincipal) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+// ApplyFn assumes that User middleware has already been run
+// otherwise it will no work correctly.
+func (mw *RequirePrincipal) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var vd views.Data
+		user, service := context.User(ctx), context.Service(ctx)
+		switch {
+		case user == nil && service == nil:
+			vd.SetError(models.ErrWrongToken)
+		case user != nil && !granted(user.Claims, mw.Scope):
+			vd.SetError(models.ErrInsufficientScope)
+		case user == nil && (mw.Scope == "" || !models.HasScope(service.Scope, mw.Scope)):
+			vd.SetError(models.ErrInsufficientScope)
+		default:
+			next(w, r)
+			return
+		}
+		views.Render(w, r, vd)
+	})
+}
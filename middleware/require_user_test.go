@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang-jwt-api/context"
+	"golang-jwt-api/cookies"
+	"golang-jwt-api/models"
+)
+
+// serve runs mw in front of a handler recording whether it was
+// reached, for a request made as user or service.
+func serve(mw func(http.HandlerFunc) http.HandlerFunc, user *models.User, service ...*models.ServicePrincipal) bool {
+	reached := false
+	handler := mw(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	r := httptest.NewRequest("GET", "/", nil)
+	if user != nil {
+		r = r.WithContext(context.WithUser(r.Context(), user))
+	}
+	for _, s := range service {
+		r = r.WithContext(context.WithService(r.Context(), s))
+	}
+	handler(httptest.NewRecorder(), r)
+	return reached
+}
+
+func userWithClaims(claims *models.JWTUser) *models.User {
+	user := &models.User{Claims: claims}
+	user.ID = 1
+	return user
+}
+
+func TestRequireUser_Scope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		user  *models.User
+		want  bool
+	}{
+		{"Anonymous", "", nil, false},
+		{"Access token", "", userWithClaims(&models.JWTUser{ID: 1, Kind: models.AccessToken}), true},
+		{"Legacy token without a kind", "", userWithClaims(&models.JWTUser{ID: 1}), true},
+		{"Access token on a scoped route", "openid", userWithClaims(&models.JWTUser{ID: 1}), true},
+		{"OAuth token on an unscoped route", "", userWithClaims(&models.JWTUser{ID: 1, Kind: models.OAuthToken, Scope: "openid"}), false},
+		{"OAuth token with the scope", "openid", userWithClaims(&models.JWTUser{ID: 1, Kind: models.OAuthToken, Scope: "openid email"}), true},
+		{"OAuth token without the scope", "openid", userWithClaims(&models.JWTUser{ID: 1, Kind: models.OAuthToken, Scope: "email"}), false},
+		{"API key on an unscoped route", "", userWithClaims(&models.JWTUser{ID: 1, Kind: models.APIKeyToken}), false},
+		{"API key with the scope", "reports", userWithClaims(&models.JWTUser{ID: 1, Kind: models.APIKeyToken, Scope: "reports"}), true},
+		{"API key without the scope", "reports", userWithClaims(&models.JWTUser{ID: 1, Kind: models.APIKeyToken}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := &RequireUser{Scope: tt.scope}
+			if got := serve(mw.ApplyFn, tt.user); got != tt.want {
+				t.Errorf("handler reached = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePrincipal_Scope(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   string
+		user    *models.User
+		service *models.ServicePrincipal
+		want    bool
+	}{
+		{"Anonymous", "users:read", nil, nil, false},
+		{"Access token", "users:read", userWithClaims(&models.JWTUser{ID: 1}), nil, true},
+		{"API key with the scope", "users:read", userWithClaims(&models.JWTUser{ID: 1, Kind: models.APIKeyToken, Scope: "users:read"}), nil, true},
+		{"API key without the scope", "users:read", userWithClaims(&models.JWTUser{ID: 1, Kind: models.APIKeyToken}), nil, false},
+		{"Service with the scope", "users:read", nil, &models.ServicePrincipal{ClientID: "cron", Scope: "reports users:read"}, true},
+		{"Service without the scope", "users:read", nil, &models.ServicePrincipal{ClientID: "cron", Scope: "reports"}, false},
+		{"Service on an unscoped route", "", nil, &models.ServicePrincipal{ClientID: "cron", Scope: "reports"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := &RequirePrincipal{Scope: tt.scope}
+			var services []*models.ServicePrincipal
+			if tt.service != nil {
+				services = append(services, tt.service)
+			}
+			if got := serve(mw.ApplyFn, tt.user, services...); got != tt.want {
+				t.Errorf("handler reached = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeUserService accepts the token "valid" as user 1.
+type fakeUserService struct {
+	models.UserService
+}
+
+func (fakeUserService) ByToken(token string) (*models.User, error) {
+	if token != "valid" {
+		return nil, models.ErrWrongToken
+	}
+	return userWithClaims(&models.JWTUser{ID: 1, Kind: models.AccessToken}), nil
+}
+
+func TestUser_CookieCSRF(t *testing.T) {
+	cookie := cookies.New()
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		want    bool
+	}{
+		{"Cookie without the CSRF header", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: "valid"})
+			r.AddCookie(&http.Cookie{Name: cookie.CSRFName, Value: "csrf"})
+		}, false},
+		{"Cookie with a matching CSRF header", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: "valid"})
+			r.AddCookie(&http.Cookie{Name: cookie.CSRFName, Value: "csrf"})
+			r.Header.Set(cookies.CSRFHeader, "csrf")
+		}, true},
+		{"Cookie with a wrong CSRF header", func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: "valid"})
+			r.AddCookie(&http.Cookie{Name: cookie.CSRFName, Value: "csrf"})
+			r.Header.Set(cookies.CSRFHeader, "other")
+		}, false},
+		{"Authorization header", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer valid")
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := &User{UserService: fakeUserService{}, Cookie: cookie}
+			authenticated := false
+			handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+				authenticated = context.User(r.Context()) != nil
+			})
+			r := httptest.NewRequest("POST", "/logout", nil)
+			tt.prepare(r)
+			handler(httptest.NewRecorder(), r)
+			if authenticated != tt.want {
+				t.Errorf("authenticated = %v; want %v", authenticated, tt.want)
+			}
+		})
+	}
+}
+
+// fakeAPIKeys accepts the key "fsk_valid" for user 1.
+type fakeAPIKeys struct {
+	models.APIKeyService
+}
+
+func (fakeAPIKeys) Authenticate(key string) (*models.APIKey, error) {
+	if key != "fsk_valid" {
+		return nil, models.ErrAPIKeyInvalid
+	}
+	return &models.APIKey{UserID: 1}, nil
+}
+
+// fakeStatusUserService returns user 1 with the given status.
+type fakeStatusUserService struct {
+	models.UserService
+	status models.StatusType
+}
+
+func (f fakeStatusUserService) ByID(id uint) (*models.User, error) {
+	user := &models.User{Status: f.status}
+	user.ID = id
+	return user, nil
+}
+
+func TestUser_APIKeyStatus(t *testing.T) {
+	tests := []struct {
+		status models.StatusType
+		want   bool
+	}{
+		{models.Active, true},
+		{"", true},
+		{models.Inactive, false},
+		{models.Pending, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			mw := &User{UserService: fakeStatusUserService{status: tt.status}, APIKeys: fakeAPIKeys{}}
+			authenticated := false
+			handler := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+				authenticated = context.User(r.Context()) != nil
+			})
+			r := httptest.NewRequest("GET", "/user", nil)
+			r.Header.Set("X-API-Key", "fsk_valid")
+			handler(httptest.NewRecorder(), r)
+			if authenticated != tt.want {
+				t.Errorf("authenticated = %v; want %v", authenticated, tt.want)
+			}
+		})
+	}
+}
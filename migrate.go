@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"golang-jwt-api/config"
+	"golang-jwt-api/models"
+)
+
+const commandUsage = `Commands:
+  migrate up         apply every pending migration
+  migrate down [N]   roll back the last N migrations (default 1)
+  migrate status     list migrations and when they were applied
+  migrate unlock     release a lock left by a crashed migration (SQLite only)
+`
+
+// runCommand runs the command named by args instead of the
+// server and returns the exit code.
+func runCommand(cfg config.Config, args []string) int {
+	if args[0] != "migrate" || len(args) < 2 {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+	dbCfg := cfg.Database
+	services, err := models.NewServices(
+		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
+		models.WithLogMode(!cfg.IsProd()),
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer services.Close()
+	if err := runMigrate(services, args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(services *models.Services, args []string) error {
+	m, err := services.Migrator()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("migrate down: %q is not a positive number of migrations", args[1])
+			}
+		}
+		rolledBack, err := m.Down(steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if status.Unknown {
+				applied += " (unknown to this release)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	case "unlock":
+		return m.Unlock()
+	}
+	fmt.Fprint(os.Stderr, commandUsage)
+	return fmt.Errorf("migrate: unknown command %q", args[0])
+}
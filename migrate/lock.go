@@ -0,0 +1,143 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// lockName identifies the migration lock on MySQL and,
+// hashed, on PostgreSQL.
+const lockName = "schema_migrations"
+
+// advisoryLockKey is the PostgreSQL advisory lock key, the
+// FNV-1a hash of lockName.
+var advisoryLockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	return int64(h.Sum64())
+}()
+
+// lockRetryInterval is how often a busy lock is tried again.
+const lockRetryInterval = 200 * time.Millisecond
+
+type locker interface {
+	lock(timeout time.Duration) error
+	unlock() error
+}
+
+// newLocker returns the lock best suited to the database:
+// MySQL's named locks and PostgreSQL's advisory locks are tied
+// to a connection, so they are released even if the process
+// dies. SQLite has neither and uses a row in a lock table.
+func newLocker(db *gorm.DB) (locker, error) {
+	switch db.Dialect().GetName() {
+	case "mysql":
+		return &connLocker{db: db.DB(),
+			lockQuery:   "SELECT GET_LOCK(?, 0)",
+			unlockQuery: "SELECT RELEASE_LOCK(?)",
+			arg:         lockName,
+		}, nil
+	case "postgres":
+		return &connLocker{db: db.DB(),
+			lockQuery:   "SELECT pg_try_advisory_lock($1)",
+			unlockQuery: "SELECT pg_advisory_unlock($1)",
+			arg:         advisoryLockKey,
+		}, nil
+	}
+	if err := db.AutoMigrate(&lockRow{}).Error; err != nil {
+		return nil, err
+	}
+	return &tableLocker{db: db}, nil
+}
+
+// retry calls try until it succeeds, fails or timeout elapses.
+func retry(timeout time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := try()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// connLocker holds a lock on a dedicated connection until it
+// is unlocked.
+type connLocker struct {
+	db          *sql.DB
+	lockQuery   string
+	unlockQuery string
+	arg         interface{}
+	conn        *sql.Conn
+}
+
+func (l *connLocker) lock(timeout time.Duration) error {
+	conn, err := l.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	err = retry(timeout, func() (bool, error) {
+		var locked bool
+		err := conn.QueryRowContext(context.Background(), l.lockQuery, l.arg).Scan(&locked)
+		return locked, err
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	l.conn = conn
+	return nil
+}
+
+func (l *connLocker) unlock() error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(context.Background(), l.unlockQuery, l.arg)
+	return err
+}
+
+// lockRow is the single row of schema_migrations_lock held
+// while migrating.
+type lockRow struct {
+	ID       uint `gorm:"primary_key;auto_increment:false"`
+	LockedAt time.Time
+}
+
+func (lockRow) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// tableLocker locks by inserting the row of
+// schema_migrations_lock; the primary key makes the insert
+// fail while another process holds it.
+type tableLocker struct {
+	db *gorm.DB
+}
+
+func (l *tableLocker) lock(timeout time.Duration) error {
+	return retry(timeout, func() (bool, error) {
+		err := l.db.Create(&lockRow{ID: 1, LockedAt: time.Now()}).Error
+		if err == nil {
+			return true, nil
+		}
+		var count int
+		if countErr := l.db.Model(&lockRow{}).Count(&count).Error; countErr != nil || count == 0 {
+			return false, err
+		}
+		return false, nil
+	})
+}
+
+func (l *tableLocker) unlock() error {
+	return l.db.Delete(&lockRow{}).Error
+}
@@ -0,0 +1,276 @@
+// Package migrate applies numbered schema migrations and
+// records them in the schema_migrations table, so a schema can
+// evolve in ways gorm's AutoMigrate cannot, such as changing
+// column types or adding constraints, and changes can be rolled
+// back.
+//
+// Migrations are applied under a lock so that several instances
+// starting at the same time do not migrate concurrently.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrLocked is returned when another process held the
+	// migration lock for longer than the lock timeout.
+	ErrLocked = errors.New("migrate: another process is migrating the database")
+	// ErrIrreversible is returned when rolling back a migration
+	// that has no Down function.
+	ErrIrreversible = errors.New("migrate: migration cannot be rolled back")
+	// ErrUnknownVersion is returned when rolling back a version
+	// that was applied by a newer release.
+	ErrUnknownVersion = errors.New("migrate: applied version is not known to this release")
+)
+
+// DefaultLockTimeout is how long Up and Down wait for another
+// process to release the migration lock.
+const DefaultLockTimeout = time.Minute
+
+// Migration changes the schema from the previous version to
+// Version. Up and Down run in a transaction along with the
+// update of schema_migrations, except on MySQL where schema
+// changes commit implicitly, so each migration should make a
+// single change.
+type Migration struct {
+	Version uint
+	Name    string
+	Up      func(tx *gorm.DB) error
+	// Down reverts Up. Migrations without it cannot be rolled
+	// back.
+	Down func(tx *gorm.DB) error
+}
+
+// Status is the state of a migration in the database.
+type Status struct {
+	Version uint
+	Name    string
+	// AppliedAt is nil for pending migrations.
+	AppliedAt *time.Time
+	// Unknown is set for versions applied by a newer release.
+	Unknown bool
+}
+
+// schemaMigration is a row of schema_migrations.
+type schemaMigration struct {
+	Version   uint   `gorm:"primary_key;auto_increment:false"`
+	Name      string `gorm:"not null;type:varchar(255)"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies a list of migrations to a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	// LockTimeout overrides DefaultLockTimeout.
+	LockTimeout time.Duration
+}
+
+// New returns a Migrator for migrations, which must have
+// distinct versions and an Up function.
+func New(db *gorm.DB, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Version == 0 || m.Up == nil {
+			return nil, fmt.Errorf("migrate: migration %d %q needs a version above 0 and an Up function", m.Version, m.Name)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migrate: version %d is used twice", m.Version)
+		}
+	}
+	return &Migrator{
+		db:          db,
+		migrations:  sorted,
+		LockTimeout: DefaultLockTimeout,
+	}, nil
+}
+
+// Up applies every pending migration in order and returns
+// those applied. It stops at the first failure, leaving the
+// migrations before it applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(func() error {
+		done, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			err := m.run(migration, migration.Up, func(tx *gorm.DB) error {
+				return tx.Create(&schemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, most
+// recent first, and returns those rolled back.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.withLock(func() error {
+		done, err := m.applied()
+		if err != nil {
+			return err
+		}
+		versions := make([]uint, 0, len(done))
+		for version := range done {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		if steps < 0 {
+			steps = 0
+		}
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		for _, version := range versions {
+			migration, ok := m.find(version)
+			if !ok {
+				return fmt.Errorf("%v: %d", ErrUnknownVersion, version)
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("%v: %d %s", ErrIrreversible, version, migration.Name)
+			}
+			err := m.run(migration, migration.Down, func(tx *gorm.DB) error {
+				return tx.Delete(&schemaMigration{Version: version}).Error
+			})
+			if err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status lists every known migration, and those applied by a
+// newer release, by version.
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.createTable(); err != nil {
+		return nil, err
+	}
+	done, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := done[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	for version, row := range done {
+		if _, ok := m.find(version); !ok {
+			appliedAt := row.AppliedAt
+			statuses = append(statuses, Status{Version: version, Name: row.Name, AppliedAt: &appliedAt, Unknown: true})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// run runs fn, then record, in a transaction.
+func (m *Migrator) run(migration Migration, fn, record func(tx *gorm.DB) error) error {
+	tx := m.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: %d %s: %v", migration.Version, migration.Name, err)
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrate: %d %s: %v", migration.Version, migration.Name, err)
+	}
+	return tx.Commit().Error
+}
+
+func (m *Migrator) applied() (map[uint]schemaMigration, error) {
+	var rows []schemaMigration
+	if err := m.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	done := make(map[uint]schemaMigration, len(rows))
+	for _, row := range rows {
+		done[row.Version] = row
+	}
+	return done, nil
+}
+
+func (m *Migrator) find(version uint) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) createTable() error {
+	if m.db.HasTable(&schemaMigration{}) {
+		return nil
+	}
+	err := m.db.CreateTable(&schemaMigration{}).Error
+	// Another process may have created it in the meantime.
+	if err != nil && !m.db.HasTable(&schemaMigration{}) {
+		return err
+	}
+	return nil
+}
+
+// withLock runs fn while holding the migration lock.
+func (m *Migrator) withLock(fn func() error) error {
+	if err := m.createTable(); err != nil {
+		return err
+	}
+	l, err := newLocker(m.db)
+	if err != nil {
+		return err
+	}
+	if err := l.lock(m.LockTimeout); err != nil {
+		return err
+	}
+	defer l.unlock()
+	return fn()
+}
+
+// Unlock releases a lock left behind by a process that died
+// while migrating. Only SQLite needs it; MySQL and PostgreSQL
+// release the lock when the connection holding it is closed.
+func (m *Migrator) Unlock() error {
+	if !m.db.HasTable(&lockRow{}) {
+		return nil
+	}
+	return m.db.Delete(&lockRow{}).Error
+}
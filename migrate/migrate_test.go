@@ -0,0 +1,175 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+type gadget struct {
+	ID uint
+}
+
+// openDB opens an in-memory SQLite database of its own for the
+// test.
+func openDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func testMigrations() []Migration {
+	return []Migration{
+		{
+			Version: 2,
+			Name:    "create_gadgets",
+			Up:      func(tx *gorm.DB) error { return tx.CreateTable(&gadget{}).Error },
+			Down:    func(tx *gorm.DB) error { return tx.DropTable(&gadget{}).Error },
+		},
+		{
+			Version: 1,
+			Name:    "create_widgets",
+			Up:      func(tx *gorm.DB) error { return tx.CreateTable(&widget{}).Error },
+			Down:    func(tx *gorm.DB) error { return tx.DropTable(&widget{}).Error },
+		},
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	db := openDB(t)
+	m, err := New(db, testMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Fatalf("Up() applied %v; want versions 1 and 2 in order", applied)
+	}
+	if !db.HasTable(&widget{}) || !db.HasTable(&gadget{}) {
+		t.Fatal("Up() should have created both tables")
+	}
+	if applied, err := m.Up(); err != nil || len(applied) != 0 {
+		t.Errorf("second Up() = %v, %v; want nothing applied", applied, err)
+	}
+
+	rolledBack, err := m.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != 1 || rolledBack[0].Version != 2 || db.HasTable(&gadget{}) {
+		t.Errorf("Down(1) rolled back %v; want only version 2", rolledBack)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil {
+		t.Errorf("Status() = %+v; want version 1 applied and 2 pending", statuses)
+	}
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	db := openDB(t)
+	migrations := append(testMigrations(), Migration{
+		Version: 3,
+		Name:    "broken",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Create(&widget{Name: "rolled back"}).Error; err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up()
+	if err == nil {
+		t.Fatal("Up() should report the failed migration")
+	}
+	if len(applied) != 2 {
+		t.Errorf("Up() applied %d migrations; want the 2 before the failure", len(applied))
+	}
+	var count int
+	db.Model(&widget{}).Count(&count)
+	if count != 0 {
+		t.Error("the changes of the failed migration should be rolled back")
+	}
+	statuses, _ := m.Status()
+	if statuses[2].AppliedAt != nil {
+		t.Error("the failed migration should not be recorded")
+	}
+}
+
+func TestMigrator_Irreversible(t *testing.T) {
+	db := openDB(t)
+	m, err := New(db, append(testMigrations(), Migration{
+		Version: 3,
+		Name:    "irreversible",
+		Up:      func(tx *gorm.DB) error { return nil },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	rolledBack, err := m.Down(3)
+	if err == nil || len(rolledBack) != 0 {
+		t.Errorf("Down() = %v, %v; want an error and nothing rolled back", rolledBack, err)
+	}
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	db := openDB(t)
+	m, err := New(db, testMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.LockTimeout = 100 * time.Millisecond
+
+	// Another instance is migrating.
+	other, err := newLocker(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.lock(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != ErrLocked {
+		t.Fatalf("Up() while locked = %v; want ErrLocked", err)
+	}
+	if err := other.unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Errorf("Up() once unlocked = %v", err)
+	}
+}
+
+func TestNew_InvalidMigrations(t *testing.T) {
+	up := func(tx *gorm.DB) error { return nil }
+	if _, err := New(nil, []Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}); err == nil {
+		t.Error("New() should reject duplicate versions")
+	}
+	if _, err := New(nil, []Migration{{Version: 1}}); err == nil {
+		t.Error("New() should reject migrations without Up")
+	}
+}
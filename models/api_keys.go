@@ -0,0 +1,139 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang-jwt-api/hash"
+	"golang-jwt-api/rand"
+)
+
+const (
+	// APIKeyPrefix starts every API key, so they can be told
+	// apart from JWTs and spotted by secret scanners.
+	APIKeyPrefix = "fsk_"
+	// APIKeyToken is the kind recorded in the claims of users
+	// authenticated with an API key.
+	APIKeyToken TokenKind = "api_key"
+
+	apiKeyBytes = 32
+)
+
+// APIKey is a long-lived credential a user creates for
+// scripts. Only an HMAC digest of the key is stored.
+type APIKey struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index" json:"-"`
+	Name      string `gorm:"not null;type:varchar(100)"`
+	KeyHash   string `gorm:"not null;type:varchar(100);unique_index" json:"-"`
+	Key       string `gorm:"-" json:"Key,omitempty"`
+	Scope     string `gorm:"type:text"`
+	ExpiresAt *time.Time
+}
+
+// Expired reports whether the key has an expiry in the past.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// APIKeyService is a set of methods used to manage the API
+// keys of users.
+type APIKeyService interface {
+	// Create generates a new key for key.UserID. The key is
+	// only available in key.Key until the key is reloaded.
+	Create(key *APIKey) error
+	ByUser(userID uint) ([]APIKey, error)
+	// Revoke deletes the key if it belongs to the user and
+	// returns ErrNotFound otherwise.
+	Revoke(userID, id uint) error
+	// RevokeAll deletes every key of the user.
+	RevokeAll(userID uint) error
+	// Authenticate returns the key matching the provided one
+	// if it has not expired, and ErrAPIKeyInvalid otherwise.
+	Authenticate(key string) (*APIKey, error)
+}
+
+// apiKeyRevoker is the part of APIKeyService the user service
+// uses to revoke the keys of a user along with their tokens.
+type apiKeyRevoker interface {
+	RevokeAll(userID uint) error
+}
+
+func NewAPIKeyService(db *gorm.DB, hmac hash.KeyedHMAC) APIKeyService {
+	return &apiKeyService{
+		db:   db,
+		hmac: hmac,
+	}
+}
+
+var _ APIKeyService = &apiKeyService{}
+
+type apiKeyService struct {
+	db   *gorm.DB
+	hmac hash.KeyedHMAC
+}
+
+func (aks *apiKeyService) Create(key *APIKey) error {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return ErrAPIKeyNameRequired
+	}
+	if key.UserID < 1 {
+		return ErrIDInvalid
+	}
+	if key.Expired() {
+		return ErrAPIKeyExpiryInvalid
+	}
+	key.Scope = strings.Join(strings.Fields(key.Scope), " ")
+
+	secret, err := rand.String(apiKeyBytes)
+	if err != nil {
+		return err
+	}
+	key.Key = APIKeyPrefix + secret
+	key.KeyHash = aks.hmac.Hash(key.Key)
+	return aks.db.Create(key).Error
+}
+
+func (aks *apiKeyService) ByUser(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := aks.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (aks *apiKeyService) Revoke(userID, id uint) error {
+	res := aks.db.Where("id = ? AND user_id = ?", id, userID).Delete(&APIKey{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (aks *apiKeyService) RevokeAll(userID uint) error {
+	return aks.db.Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
+
+func (aks *apiKeyService) Authenticate(key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+	var found APIKey
+	err := first(aks.db.Where("key_hash IN (?)", aks.hmac.Digests(key)), &found)
+	if err == ErrNotFound {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrAPIKeyInvalid
+	}
+	return &found, nil
+}
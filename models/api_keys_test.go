@@ -0,0 +1,81 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPIKeyRevoker records the users whose keys were revoked.
+type fakeAPIKeyRevoker struct {
+	mu      sync.Mutex
+	revoked map[uint]int
+}
+
+func (f *fakeAPIKeyRevoker) RevokeAll(userID uint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.revoked == nil {
+		f.revoked = make(map[uint]int)
+	}
+	f.revoked[userID]++
+	return nil
+}
+
+func (f *fakeAPIKeyRevoker) count(userID uint) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.revoked[userID]
+}
+
+func TestAPIKey_Expired(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		want      bool
+	}{
+		{"No expiry", nil, false},
+		{"Expired", &past, true},
+		{"Not expired yet", &future, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := APIKey{ExpiresAt: tt.expiresAt}
+			if got := key.Expired(); got != tt.want {
+				t.Errorf("Expired() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyService_AuthenticateRequiresPrefix(t *testing.T) {
+	// Keys without the prefix are rejected before the database
+	// is queried, so no database is needed here.
+	aks := &apiKeyService{}
+	if _, err := aks.Authenticate("eyJhbGciOiJSUzUxMiJ9.e30.sig"); err != ErrAPIKeyInvalid {
+		t.Errorf("Authenticate() = %v; want ErrAPIKeyInvalid", err)
+	}
+}
+
+func TestAPIKeyService_CreateValidation(t *testing.T) {
+	aks := &apiKeyService{}
+	past := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name string
+		key  APIKey
+		want error
+	}{
+		{"Missing name", APIKey{UserID: 1, Name: "  "}, ErrAPIKeyNameRequired},
+		{"Missing user", APIKey{Name: "deploy"}, ErrIDInvalid},
+		{"Expiry in the past", APIKey{UserID: 1, Name: "deploy", ExpiresAt: &past}, ErrAPIKeyExpiryInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := aks.Create(&tt.key); err != tt.want {
+				t.Errorf("Create() = %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -11,12 +11,22 @@ var (
 	// ErrPasswordIncorrect is returned when an invalid password
 	// is used when attempting to authenticate a user.
 	ErrPasswordIncorrect modelError = "models: incorrect password provided"
+	// ErrInvalidCredentials is returned by Authenticate when
+	// either the email address or the password is wrong, so
+	// callers cannot tell which one it was.
+	ErrInvalidCredentials modelError = "models: invalid email address or password"
+	// ErrSignupFailed replaces ErrEmailTaken and ErrUsernameTaken
+	// when accounts are concealed.
+	ErrSignupFailed modelError = "models: unable to create an account with the provided details"
 	// ErrEmailRequired is returned when an email address is
 	// not provided when creating a user
 	ErrEmailRequired modelError = "models: email address is required"
 	// ErrEmailRequired is returned when an email address is
 	// not provided when creating a user
 	ErrUsernameRequired modelError = "models: username is required"
+	// ErrStatusInvalid is returned when a user is saved with a
+	// status other than active, inactive or pending.
+	ErrStatusInvalid modelError = "models: status is not valid"
 	// ErrEmailInvalid is returned when an email address provided
 	// does not match any of our requirements
 	ErrEmailInvalid modelError = "models: email address is not valid"
@@ -35,6 +45,62 @@ var (
 	ErrCannotBeTheSameWithOldPassword modelError = "models: New password cannot be the same with the old password"
 	ErrWrongToken modelError = "models: The access token provided is invalid."
 	ErrTokenExpired modelError = "models: The access token provided is expired."
+	// ErrTokenRevoked is returned for tokens issued before the
+	// user's tokens were last revoked, e.g. by a password change.
+	ErrTokenRevoked modelError = "models: The access token provided has been revoked."
+	// ErrSessionRevoked is returned for tokens whose session
+	// was signed out.
+	ErrSessionRevoked modelError = "models: The session of the access token provided has been signed out."
+	// ErrTokenNotValidYet is returned for tokens whose "nbf"
+	// claim lies in the future.
+	ErrTokenNotValidYet modelError = "models: The access token provided is not valid yet."
+	// ErrTokenIssuedInFuture is returned for tokens whose "iat"
+	// claim lies in the future.
+	ErrTokenIssuedInFuture modelError = "models: The access token provided was issued in the future."
+	ErrTokenIssuerInvalid modelError = "models: The access token provided has an invalid issuer."
+	ErrTokenAudienceInvalid modelError = "models: The access token provided is not meant for this service."
+	// ErrAudienceNotRegistered is returned when a token is
+	// requested for an audience that is not registered.
+	ErrAudienceNotRegistered modelError = "models: the requested audience is not registered"
+	ErrTokenKindInvalid modelError = "models: The token provided cannot be used as an access token."
+	ErrTokenSubjectInvalid modelError = "models: The access token provided has an invalid subject."
+	// ErrTokenClaimMissing is returned for tokens missing one
+	// of the required claims.
+	ErrTokenClaimMissing modelError = "models: The access token provided is missing a required claim."
+	ErrClientNameRequired modelError = "models: client name is required"
+	ErrClientUnknown modelError = "models: client is not registered"
+	// ErrClientAuthFailed is returned when a client ID and
+	// secret do not match a confidential client.
+	ErrClientAuthFailed modelError = "models: client authentication failed"
+	// ErrRedirectURIInvalid is returned when a redirect URI is
+	// not registered for the client, or cannot be registered.
+	ErrRedirectURIInvalid modelError = "models: redirect URI is not valid for this client"
+	ErrCodeChallengeRequired modelError = "models: a S256 PKCE code challenge is required"
+	// ErrAuthorizationCodeInvalid is returned for authorization
+	// codes that are unknown, expired, already used or do not
+	// match the token request.
+	ErrAuthorizationCodeInvalid modelError = "models: authorization code is invalid"
+	// ErrScopeInvalid is returned when a client requests a
+	// scope it was not registered with.
+	ErrScopeInvalid modelError = "models: requested scope is not allowed for this client"
+	// ErrInsufficientScope is returned when a request is made
+	// with a token or API key that was not granted the scope
+	// the route requires.
+	ErrInsufficientScope modelError = "models: the credentials provided were not granted the scope this request requires"
+	// ErrServiceAccountNotAllowed is returned when a user who
+	// was not allowed to registers a service account.
+	ErrServiceAccountNotAllowed modelError = "models: you are not allowed to register service accounts"
+	// ErrNotServiceAccount is returned when a client that is not
+	// a service account uses the client credentials grant.
+	ErrNotServiceAccount modelError = "models: client is not a service account"
+	ErrAPIKeyNameRequired modelError = "models: API key name is required"
+	ErrAPIKeyExpiryInvalid modelError = "models: API key expiry must be in the future"
+	// ErrAPIKeyInvalid is returned for API keys that are
+	// unknown, revoked or expired.
+	ErrAPIKeyInvalid modelError = "models: The API key provided is not valid."
+	// ErrAPIKeyNotAllowed is returned when a request made with
+	// an API key tries to manage API keys.
+	ErrAPIKeyNotAllowed modelError = "models: API keys cannot be managed with an API key"
 	// ErrIDInvalid is returned when an invalid ID is provided
 	// to a method like Delete.
 	ErrIDInvalid privateError = "models: ID provided was invalid"
@@ -46,6 +112,16 @@ var (
 	ErrRememberTooShort privateError = "models: remember token must be at least 32 bytes"
 	ErrUserIDRequired   privateError = "models: user ID is required"
 	ErrSignedStringToken privateError = "models: Cannot create token"
+	// ErrSigningKeyMismatch is returned by Reload when the new
+	// signing key does not belong to the new public key.
+	ErrSigningKeyMismatch privateError = "models: signing key does not match the public key"
+	// ErrClaimUnknown is returned by Reload when a required
+	// claim is not one TokenValidator can check, which would
+	// reject every token.
+	ErrClaimUnknown privateError = "models: unknown required claim"
+	// ErrPepperVersionUnknown is returned when a password hash
+	// was created with a pepper that is no longer configured.
+	ErrPepperVersionUnknown privateError = "models: pepper version is not configured"
 )
 
 type modelError string
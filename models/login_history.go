@@ -0,0 +1,218 @@
+package models
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Outcomes of a login attempt.
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "invalid_credentials"
+)
+
+const (
+	// DefaultLoginHistoryLimit is the number of attempts
+	// returned when no limit is requested.
+	DefaultLoginHistoryLimit = 50
+	maxLoginHistoryLimit     = 200
+	// maxPendingLoginRecords bounds the failed logins being
+	// recorded in the background, so a flood of wrong passwords
+	// cannot pile up goroutines and database writes.
+	maxPendingLoginRecords = 32
+)
+
+// LoginAttempt records a login to an existing account.
+// Attempts with unknown email addresses are not recorded,
+// since there is no account to show them to.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	IP        string    `gorm:"type:varchar(45)"`
+	// Network is the /24 (IPv4) or /48 (IPv6) network of IP,
+	// used to recognize logins from a known network.
+	Network   string `gorm:"type:varchar(50)" json:"-"`
+	UserAgent string `gorm:"type:text"`
+	Outcome   string `gorm:"type:varchar(32)"`
+}
+
+// LoginNotifier is told about successful logins from a device
+// or network the account has not logged in from before, e.g.
+// to email the user. It is called synchronously during login,
+// so slow notifiers should queue the work instead.
+type LoginNotifier interface {
+	NewDeviceLogin(user *User, attempt *LoginAttempt) error
+}
+
+// LoginNotifierFunc adapts a function to a LoginNotifier.
+type LoginNotifierFunc func(user *User, attempt *LoginAttempt) error
+
+func (f LoginNotifierFunc) NewDeviceLogin(user *User, attempt *LoginAttempt) error {
+	return f(user, attempt)
+}
+
+// WithLoginNotifier sets the notifier for logins from new
+// devices. Without it nobody is notified.
+func WithLoginNotifier(notifier LoginNotifier) UserServiceConfig {
+	return func(us *userService) {
+		us.notifier = notifier
+	}
+}
+
+// LoginHistoryDB is used to interact with the login history
+// database.
+type LoginHistoryDB interface {
+	Create(attempt *LoginAttempt) error
+	// ByUser returns the most recent attempts first.
+	ByUser(userID uint, limit int) ([]LoginAttempt, error)
+	// Succeeded reports whether the user has ever logged in
+	// successfully.
+	Succeeded(userID uint) (bool, error)
+	// Seen reports whether the user has logged in successfully
+	// before with the user agent and from the network.
+	Seen(userID uint, userAgent, network string) (device, sameNetwork bool, err error)
+	// DeleteBefore deletes the attempts made before t and
+	// returns how many there were.
+	DeleteBefore(t time.Time) (int64, error)
+}
+
+var _ LoginHistoryDB = &loginHistoryGorm{}
+
+type loginHistoryGorm struct {
+	db *gorm.DB
+}
+
+func (lg *loginHistoryGorm) Create(attempt *LoginAttempt) error {
+	return lg.db.Create(attempt).Error
+}
+
+func (lg *loginHistoryGorm) ByUser(userID uint, limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := lg.db.Where("user_id = ?", userID).Order("id desc").Limit(limit).Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (lg *loginHistoryGorm) Succeeded(userID uint) (bool, error) {
+	var successes int
+	err := lg.db.Model(&LoginAttempt{}).Where("user_id = ? AND outcome = ?", userID, LoginSucceeded).Count(&successes).Error
+	return successes > 0, err
+}
+func (lg *loginHistoryGorm) Seen(userID uint, userAgent, network string) (bool, bool, error) {
+	var devices, networks int
+	successes := lg.db.Model(&LoginAttempt{}).Where("user_id = ? AND outcome = ?", userID, LoginSucceeded)
+	if err := successes.Where("user_agent = ?", userAgent).Count(&devices).Error; err != nil {
+		return false, false, err
+	}
+	if err := successes.Where("network = ?", network).Count(&networks).Error; err != nil {
+		return false, false, err
+	}
+	return devices > 0, networks > 0, nil
+}
+
+func (lg *loginHistoryGorm) DeleteBefore(t time.Time) (int64, error) {
+	res := lg.db.Where("created_at < ?", t).Delete(&LoginAttempt{})
+	return res.RowsAffected, res.Error
+}
+
+// LoginHistory returns the most recent login attempts to the
+// user's account. A limit of zero or less returns the default
+// number of attempts.
+func (us *userService) LoginHistory(userID uint, limit int) ([]LoginAttempt, error) {
+	if limit <= 0 {
+		limit = DefaultLoginHistoryLimit
+	}
+	if limit > maxLoginHistoryLimit {
+		limit = maxLoginHistoryLimit
+	}
+	return us.logins.ByUser(userID, limit)
+}
+
+// PruneLoginHistory deletes the login attempts made before t,
+// so the history is not kept forever, and returns how many
+// there were.
+func (us *userService) PruneLoginHistory(before time.Time) (int64, error) {
+	return us.logins.DeleteBefore(before)
+}
+
+// recordLogin records a login attempt to the user's account
+// and notifies the notifier if it succeeded from a new device
+// or network. The first login to an account is never reported
+// as new, since there is nothing to compare it with. Failing
+// to record or notify does not fail the login; it is logged.
+// The attempt is recorded even if the history cannot be
+// compared with.
+func (us *userService) recordLogin(user *User, req tokenRequest, outcome string) {
+	attempt := &LoginAttempt{
+		UserID:    user.ID,
+		IP:        req.ip,
+		Network:   networkOf(req.ip),
+		UserAgent: req.userAgent,
+		Outcome:   outcome,
+	}
+	newDevice := false
+	if outcome == LoginSucceeded && us.notifier != nil {
+		var err error
+		if newDevice, err = us.newDevice(attempt); err != nil {
+			log.Println(err)
+		}
+	}
+	if err := us.logins.Create(attempt); err != nil {
+		log.Println(err)
+		return
+	}
+	if newDevice {
+		if err := us.notifier.NewDeviceLogin(user, attempt); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// recordLoginAsync runs recordLogin in the background. Once
+// maxPendingLoginRecords are pending, further attempts are
+// dropped and logged instead.
+func (us *userService) recordLoginAsync(user *User, req tokenRequest, outcome string) {
+	select {
+	case us.pendingLogins <- struct{}{}:
+		go func() {
+			defer func() { <-us.pendingLogins }()
+			us.recordLogin(user, req, outcome)
+		}()
+	default:
+		log.Printf("models: too many pending login records, dropped %s attempt for user %d", outcome, user.ID)
+	}
+}
+
+// newDevice reports whether a successful attempt comes from a
+// device or network the user has not logged in from before.
+func (us *userService) newDevice(attempt *LoginAttempt) (bool, error) {
+	// Failed attempts do not count: they may be anyone's.
+	succeeded, err := us.logins.Succeeded(attempt.UserID)
+	if err != nil || !succeeded {
+		return false, err
+	}
+	device, network, err := us.logins.Seen(attempt.UserID, attempt.UserAgent, attempt.Network)
+	if err != nil {
+		return false, err
+	}
+	return !device || !network, nil
+}
+
+// networkOf returns the /24 network of an IPv4 address or the
+// /48 network of an IPv6 address.
+func networkOf(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
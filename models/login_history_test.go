@@ -0,0 +1,245 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLoginHistoryDB keeps login attempts in a slice. Failed
+// logins are recorded in the background, so it is safe for
+// concurrent use.
+type fakeLoginHistoryDB struct {
+	mu       sync.Mutex
+	attempts []LoginAttempt
+	// err, if set, is returned by ByUser and Seen.
+	err error
+	// block, if set, holds up Create until it is closed.
+	block chan struct{}
+}
+
+func (f *fakeLoginHistoryDB) Create(attempt *LoginAttempt) error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+	f.attempts = append(f.attempts, *attempt)
+	return nil
+}
+
+func (f *fakeLoginHistoryDB) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.attempts)
+}
+
+func (f *fakeLoginHistoryDB) ByUser(userID uint, limit int) ([]LoginAttempt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	var attempts []LoginAttempt
+	for i := len(f.attempts) - 1; i >= 0 && len(attempts) < limit; i-- {
+		if f.attempts[i].UserID == userID {
+			attempts = append(attempts, f.attempts[i])
+		}
+	}
+	return attempts, nil
+}
+
+func (f *fakeLoginHistoryDB) Succeeded(userID uint) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, f.err
+	}
+	for _, a := range f.attempts {
+		if a.UserID == userID && a.Outcome == LoginSucceeded {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeLoginHistoryDB) Seen(userID uint, userAgent, network string) (bool, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return false, false, f.err
+	}
+	var device, sameNetwork bool
+	for _, a := range f.attempts {
+		if a.UserID != userID || a.Outcome != LoginSucceeded {
+			continue
+		}
+		device = device || a.UserAgent == userAgent
+		sameNetwork = sameNetwork || a.Network == network
+	}
+	return device, sameNetwork, nil
+}
+
+func (f *fakeLoginHistoryDB) DeleteBefore(t time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	kept := f.attempts[:0]
+	for _, a := range f.attempts {
+		if !a.CreatedAt.Before(t) {
+			kept = append(kept, a)
+		}
+	}
+	deleted := int64(len(f.attempts) - len(kept))
+	f.attempts = kept
+	return deleted, nil
+}
+
+func TestUserService_RecordLogin(t *testing.T) {
+	var notified []LoginAttempt
+	us := &userService{
+		logins: &fakeLoginHistoryDB{},
+		notifier: LoginNotifierFunc(func(user *User, attempt *LoginAttempt) error {
+			notified = append(notified, *attempt)
+			return nil
+		}),
+	}
+	user := &User{}
+	user.ID = 1
+	login := func(userAgent, ip, outcome string) tokenRequest {
+		req := newTokenRequest([]TokenOption{ForDevice(userAgent, ip)})
+		us.recordLogin(user, req, outcome)
+		return req
+	}
+
+	tests := []struct {
+		name         string
+		userAgent    string
+		ip           string
+		outcome      string
+		wantNotified bool
+	}{
+		{"First login", "firefox", "198.51.100.7", LoginSucceeded, false},
+		{"Same device and network", "firefox", "198.51.100.9", LoginSucceeded, false},
+		{"Failed login from a new device", "curl", "203.0.113.5", LoginFailed, false},
+		{"New device", "chrome", "198.51.100.7", LoginSucceeded, true},
+		{"New network", "firefox", "203.0.113.5", LoginSucceeded, true},
+		{"Known device and network again", "chrome", "203.0.113.80", LoginSucceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(notified)
+			login(tt.userAgent, tt.ip, tt.outcome)
+			if got := len(notified) > before; got != tt.wantNotified {
+				t.Errorf("notified = %v; want %v", got, tt.wantNotified)
+			}
+		})
+	}
+
+	history, err := us.LoginHistory(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(tests) || history[0].UserAgent != "chrome" {
+		t.Errorf("LoginHistory() returned %d attempts, most recent %+v", len(history), history[0])
+	}
+}
+
+func TestUserService_RecordLoginAfterFailure(t *testing.T) {
+	notified := false
+	us := &userService{
+		logins: &fakeLoginHistoryDB{},
+		notifier: LoginNotifierFunc(func(user *User, attempt *LoginAttempt) error {
+			notified = true
+			return nil
+		}),
+	}
+	user := &User{}
+	user.ID = 1
+	us.recordLogin(user, newTokenRequest([]TokenOption{ForDevice("firefox", "198.51.100.7")}), LoginFailed)
+	us.recordLogin(user, newTokenRequest([]TokenOption{ForDevice("firefox", "198.51.100.7")}), LoginSucceeded)
+	if notified {
+		t.Error("the first successful login should not be reported as new after a failed one")
+	}
+}
+
+func TestUserService_RecordLoginHistoryError(t *testing.T) {
+	logins := &fakeLoginHistoryDB{err: errors.New("boom")}
+	notified := false
+	us := &userService{
+		logins: logins,
+		notifier: LoginNotifierFunc(func(user *User, attempt *LoginAttempt) error {
+			notified = true
+			return nil
+		}),
+	}
+	user := &User{}
+	user.ID = 1
+	us.recordLogin(user, newTokenRequest([]TokenOption{ForDevice("firefox", "198.51.100.7")}), LoginSucceeded)
+	if logins.count() != 1 {
+		t.Errorf("recorded %d attempts; want the attempt recorded although the history could not be read", logins.count())
+	}
+	if notified {
+		t.Error("the notifier should not be called when the history could not be read")
+	}
+}
+
+func TestUserService_RecordLoginAsync(t *testing.T) {
+	logins := &fakeLoginHistoryDB{block: make(chan struct{})}
+	us := &userService{
+		logins:        logins,
+		pendingLogins: make(chan struct{}, maxPendingLoginRecords),
+	}
+	user := &User{}
+	user.ID = 1
+	req := newTokenRequest(nil)
+	for i := 0; i < maxPendingLoginRecords+10; i++ {
+		us.recordLoginAsync(user, req, LoginFailed)
+	}
+	if n := len(us.pendingLogins); n != maxPendingLoginRecords {
+		t.Errorf("%d records pending; want at most %d", n, maxPendingLoginRecords)
+	}
+	close(logins.block)
+	deadline := time.Now().Add(time.Second)
+	for len(us.pendingLogins) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := logins.count(); n != maxPendingLoginRecords {
+		t.Errorf("recorded %d attempts; want %d, the rest dropped", n, maxPendingLoginRecords)
+	}
+}
+
+func TestUserService_PruneLoginHistory(t *testing.T) {
+	logins := &fakeLoginHistoryDB{}
+	us := &userService{logins: logins}
+	now := time.Now()
+	logins.Create(&LoginAttempt{UserID: 1, CreatedAt: now.Add(-48 * time.Hour)})
+	logins.Create(&LoginAttempt{UserID: 1, CreatedAt: now})
+	deleted, err := us.PruneLoginHistory(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || logins.count() != 1 {
+		t.Errorf("PruneLoginHistory() deleted %d, kept %d; want 1 and 1", deleted, logins.count())
+	}
+}
+
+func TestNetworkOf(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"198.51.100.7", "198.51.100.0/24"},
+		{"2001:db8:1234:5678::1", "2001:db8:1234::/48"},
+		{"::ffff:198.51.100.7", "198.51.100.0/24"},
+		{"not an ip", "not an ip"},
+	}
+	for _, tt := range tests {
+		if got := networkOf(tt.ip); got != tt.want {
+			t.Errorf("networkOf(%q) = %q; want %q", tt.ip, got, tt.want)
+		}
+	}
+}
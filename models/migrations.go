@@ -0,0 +1,123 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang-jwt-api/migrate"
+)
+
+// migrations evolve the schema. Applied migrations must never
+// change: add a new one instead. They use their own copies of
+// the models, frozen as they were when the migration was
+// written, so later changes to the models cannot alter them.
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		// AutoMigrate, rather than CreateTable, adopts databases
+		// created before migrations were versioned.
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userV1{}, &oauthClientV1{}, &authorizationCodeV1{}, &apiKeyV1{}, &sessionV1{}, &loginAttemptV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&userV1{}, &oauthClientV1{}, &authorizationCodeV1{}, &apiKeyV1{}, &sessionV1{}, &loginAttemptV1{}).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "users_status_varchar",
+		// MySQL databases created by AutoMigrate store the status
+		// in an ENUM column, which AutoMigrate never changes.
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() != "mysql" {
+				return nil
+			}
+			return tx.Model(&userV1{}).ModifyColumn("status", "varchar(16) NOT NULL").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() != "mysql" {
+				return nil
+			}
+			return tx.Model(&userV1{}).ModifyColumn("status", "ENUM('active', 'inactive', 'pending') NOT NULL").Error
+		},
+	},
+}
+
+type userV1 struct {
+	gorm.Model
+	Username        string `gorm:"not null;type:varchar(100);unique_index"`
+	Email           string `gorm:"unique_index;type:varchar(100)"`
+	EmailVerified   bool   `gorm:"not null;default:false"`
+	PasswordHash    string `gorm:"not null"`
+	PepperVersion   int    `gorm:"not null;default:0"`
+	ChangedPassword time.Time
+	TokenVersion    uint   `gorm:"not null;default:0"`
+	Status          string `gorm:"not null;type:varchar(16)"`
+}
+
+func (userV1) TableName() string { return "users" }
+
+type oauthClientV1 struct {
+	gorm.Model
+	ClientID       string `gorm:"not null;type:varchar(64);unique_index"`
+	Name           string `gorm:"not null;type:varchar(100)"`
+	SecretHash     string
+	RedirectURIs   string `gorm:"type:text"`
+	OwnerID        uint   `gorm:"index"`
+	ServiceAccount bool   `gorm:"not null;default:false"`
+	Scope          string `gorm:"type:text"`
+}
+
+func (oauthClientV1) TableName() string { return "oauth_clients" }
+
+type authorizationCodeV1 struct {
+	gorm.Model
+	CodeHash      string `gorm:"not null;type:varchar(100);unique_index"`
+	ClientID      string `gorm:"not null;type:varchar(64)"`
+	UserID        uint   `gorm:"not null"`
+	RedirectURI   string `gorm:"type:text"`
+	Scope         string
+	CodeChallenge string `gorm:"not null"`
+	Nonce         string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	Used          bool `gorm:"not null;default:false"`
+}
+
+func (authorizationCodeV1) TableName() string { return "authorization_codes" }
+
+type apiKeyV1 struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null;type:varchar(100)"`
+	KeyHash   string `gorm:"not null;type:varchar(100);unique_index"`
+	Scope     string `gorm:"type:text"`
+	ExpiresAt *time.Time
+}
+
+func (apiKeyV1) TableName() string { return "api_keys" }
+
+type sessionV1 struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	TokenID    string `gorm:"not null;type:varchar(64);unique_index"`
+	UserAgent  string `gorm:"type:text"`
+	IP         string `gorm:"type:varchar(45)"`
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+func (sessionV1) TableName() string { return "sessions" }
+
+type loginAttemptV1 struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	UserID    uint      `gorm:"not null;index"`
+	IP        string    `gorm:"type:varchar(45)"`
+	Network   string    `gorm:"type:varchar(50)"`
+	UserAgent string    `gorm:"type:text"`
+	Outcome   string    `gorm:"type:varchar(32)"`
+}
+
+func (loginAttemptV1) TableName() string { return "login_attempts" }
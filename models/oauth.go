@@ -0,0 +1,358 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang-jwt-api/hash"
+	"golang-jwt-api/rand"
+)
+
+const (
+	// authorizationCodeLifetime is the maximum lifetime
+	// recommended by RFC 6749 section 4.1.2.
+	authorizationCodeLifetime = 10 * time.Minute
+	// CodeChallengeS256 is the only PKCE code challenge method
+	// we accept, since "plain" offers no protection if the
+	// authorization request is intercepted.
+	CodeChallengeS256 = "S256"
+
+	clientIDBytes     = 16
+	clientSecretBytes = 32
+	codeBytes         = 32
+)
+
+// OAuthClient is a third-party application allowed to sign
+// users in through the authorization code flow, or a service
+// account obtaining tokens for itself through the client
+// credentials grant.
+type OAuthClient struct {
+	gorm.Model
+	ClientID     string `gorm:"not null;type:varchar(64);unique_index"`
+	Name         string `gorm:"not null;type:varchar(100)"`
+	SecretHash   string `json:"-"`
+	Secret       string `gorm:"-" json:"Secret,omitempty"`
+	RedirectURIs string `gorm:"type:text"`
+	OwnerID      uint   `gorm:"index" json:"-"`
+	// ServiceAccount clients are always confidential, have no
+	// redirect URIs and may only request the space separated
+	// scopes in Scope.
+	ServiceAccount bool   `gorm:"not null;default:false"`
+	Scope          string `gorm:"type:text"`
+}
+
+// Confidential reports whether the client has a secret it
+// must authenticate with at the token endpoint.
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// GrantScope returns the scope to grant a service account
+// for the requested one. Every requested scope must be one the
+// client was registered with; an empty request grants them
+// all.
+func (c *OAuthClient) GrantScope(requested string) (string, error) {
+	if !c.ServiceAccount {
+		return "", ErrNotServiceAccount
+	}
+	if requested == "" {
+		return c.Scope, nil
+	}
+	allowed := make(map[string]bool)
+	for _, s := range strings.Fields(c.Scope) {
+		allowed[s] = true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !allowed[s] {
+			return "", ErrScopeInvalid
+		}
+	}
+	return strings.Join(strings.Fields(requested), " "), nil
+}
+
+// HasScope reports whether the space separated scope contains
+// the given one.
+func HasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectURIList returns the registered redirect URIs, which
+// are stored space separated.
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// AuthorizationCode is a single use code bound to the PKCE
+// code challenge of the authorization request. Only an HMAC
+// digest of the code is stored.
+type AuthorizationCode struct {
+	gorm.Model
+	CodeHash      string `gorm:"not null;type:varchar(100);unique_index"`
+	ClientID      string `gorm:"not null;type:varchar(64)"`
+	UserID        uint   `gorm:"not null"`
+	RedirectURI   string `gorm:"type:text"`
+	Scope         string
+	CodeChallenge string `gorm:"not null"`
+	Nonce         string
+	AuthTime      time.Time
+	ExpiresAt     time.Time
+	Used          bool `gorm:"not null;default:false"`
+}
+
+// AuthorizationRequest holds the parameters of an approved
+// authorization request needed to issue a code.
+type AuthorizationRequest struct {
+	Client *OAuthClient
+	UserID uint
+	// RedirectURI is the redirect_uri parameter as sent by the
+	// client, which may be empty.
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Nonce and AuthTime end up in the OpenID Connect ID token.
+	Nonce    string
+	AuthTime time.Time
+}
+
+// OAuthService is a set of methods used to run an OAuth 2.0
+// authorization server on top of the user service.
+type OAuthService interface {
+	// RegisterClient creates the client with a new client ID.
+	// Confidential clients and service accounts also get a
+	// secret, which is only available in client.Secret until
+	// the client is reloaded. Service accounts can only be
+	// registered as allowed by WithServiceAccountPolicy.
+	RegisterClient(client *OAuthClient, confidential bool) error
+	ClientByID(clientID string) (*OAuthClient, error)
+	// AuthenticateClient returns the client if the secret is
+	// correct and ErrClientAuthFailed otherwise.
+	AuthenticateClient(clientID, secret string) (*OAuthClient, error)
+	// RedirectURI returns the URI to redirect to for the
+	// requested redirect_uri, which must exactly match one of
+	// the registered ones. If none was requested, the client
+	// must have a single registered redirect URI.
+	RedirectURI(client *OAuthClient, requested string) (string, error)
+	CreateAuthorizationCode(req AuthorizationRequest) (string, error)
+	// ExchangeAuthorizationCode redeems the code, which can
+	// only be done once, by the client it was issued to, with
+	// the same redirect_uri and the PKCE code verifier.
+	ExchangeAuthorizationCode(client *OAuthClient, code, redirectURI, codeVerifier string) (*AuthorizationCode, error)
+}
+
+type OAuthServiceConfig func(*oauthService)
+
+// WithServiceAccountPolicy lets the users whose IDs are in
+// owners register service accounts, with scopes out of those in
+// scopes. Without it nobody can.
+func WithServiceAccountPolicy(owners []uint, scopes []string) OAuthServiceConfig {
+	return func(oas *oauthService) {
+		oas.serviceAccountOwners = make(map[uint]bool, len(owners))
+		for _, id := range owners {
+			oas.serviceAccountOwners[id] = true
+		}
+		oas.serviceAccountScopes = make(map[string]bool, len(scopes))
+		for _, scope := range scopes {
+			oas.serviceAccountScopes[scope] = true
+		}
+	}
+}
+
+func NewOAuthService(db *gorm.DB, hmac hash.KeyedHMAC, cfgs ...OAuthServiceConfig) OAuthService {
+	oas := &oauthService{
+		db:   db,
+		hmac: hmac,
+	}
+	for _, cfg := range cfgs {
+		cfg(oas)
+	}
+	return oas
+}
+
+var _ OAuthService = &oauthService{}
+
+type oauthService struct {
+	db                   *gorm.DB
+	hmac                 hash.KeyedHMAC
+	serviceAccountOwners map[uint]bool
+	serviceAccountScopes map[string]bool
+}
+
+func (oas *oauthService) RegisterClient(client *OAuthClient, confidential bool) error {
+	client.Name = strings.TrimSpace(client.Name)
+	if client.Name == "" {
+		return ErrClientNameRequired
+	}
+	uris := client.RedirectURIList()
+	if client.ServiceAccount {
+		if !oas.serviceAccountOwners[client.OwnerID] {
+			return ErrServiceAccountNotAllowed
+		}
+		for _, scope := range strings.Fields(client.Scope) {
+			if !oas.serviceAccountScopes[scope] {
+				return ErrScopeInvalid
+			}
+		}
+		if len(uris) != 0 {
+			return ErrRedirectURIInvalid
+		}
+		client.Scope = strings.Join(strings.Fields(client.Scope), " ")
+		confidential = true
+	} else if len(uris) == 0 {
+		return ErrRedirectURIInvalid
+	}
+	for _, uri := range uris {
+		if !validRedirectURI(uri) {
+			return ErrRedirectURIInvalid
+		}
+	}
+
+	clientID, err := rand.String(clientIDBytes)
+	if err != nil {
+		return err
+	}
+	client.ClientID = clientID
+	if confidential {
+		secret, err := rand.String(clientSecretBytes)
+		if err != nil {
+			return err
+		}
+		client.Secret = secret
+		client.SecretHash = oas.hmac.Hash(secret)
+	}
+	return oas.db.Create(client).Error
+}
+
+// validRedirectURI accepts absolute https URIs without a
+// fragment, and http URIs on the loopback interface for
+// native and development clients.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+func (oas *oauthService) ClientByID(clientID string) (*OAuthClient, error) {
+	var client OAuthClient
+	err := first(oas.db.Where("client_id = ?", clientID), &client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (oas *oauthService) AuthenticateClient(clientID, secret string) (*OAuthClient, error) {
+	client, err := oas.ClientByID(clientID)
+	if err == ErrNotFound {
+		return nil, ErrClientAuthFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential() || !oas.hmac.Verify(secret, client.SecretHash) {
+		return nil, ErrClientAuthFailed
+	}
+	return client, nil
+}
+
+func (oas *oauthService) RedirectURI(client *OAuthClient, requested string) (string, error) {
+	registered := client.RedirectURIList()
+	if requested == "" {
+		if len(registered) != 1 {
+			return "", ErrRedirectURIInvalid
+		}
+		return registered[0], nil
+	}
+	for _, uri := range registered {
+		if uri == requested {
+			return uri, nil
+		}
+	}
+	return "", ErrRedirectURIInvalid
+}
+
+func (oas *oauthService) CreateAuthorizationCode(req AuthorizationRequest) (string, error) {
+	if req.CodeChallengeMethod != CodeChallengeS256 || req.CodeChallenge == "" {
+		return "", ErrCodeChallengeRequired
+	}
+	code, err := rand.String(codeBytes)
+	if err != nil {
+		return "", err
+	}
+	ac := AuthorizationCode{
+		CodeHash:      oas.hmac.Hash(code),
+		ClientID:      req.Client.ClientID,
+		UserID:        req.UserID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		AuthTime:      req.AuthTime,
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+	}
+	if err := oas.db.Create(&ac).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+func (oas *oauthService) ExchangeAuthorizationCode(client *OAuthClient, code, redirectURI, codeVerifier string) (*AuthorizationCode, error) {
+	var ac AuthorizationCode
+	err := first(oas.db.Where("code_hash IN (?)", oas.hmac.Digests(code)), &ac)
+	if err == ErrNotFound {
+		return nil, ErrAuthorizationCodeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Mark the code as used before checking anything else, so
+	// a code can never be redeemed twice, even concurrently.
+	res := oas.db.Model(&AuthorizationCode{}).
+		Where("id = ? AND used = ?", ac.ID, false).
+		Update("used", true)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrAuthorizationCodeInvalid
+	}
+
+	switch {
+	case ac.ClientID != client.ClientID,
+		ac.RedirectURI != redirectURI,
+		time.Now().After(ac.ExpiresAt),
+		!verifyCodeChallenge(ac.CodeChallenge, codeVerifier):
+		return nil, ErrAuthorizationCodeInvalid
+	}
+	return &ac, nil
+}
+
+// verifyCodeChallenge checks an RFC 7636 S256 code verifier
+// against the code challenge of the authorization request.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return hash.Equal(base64.RawURLEncoding.EncodeToString(sum[:]), challenge)
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"testing"
+
+	"golang-jwt-api/hash"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mJ0NRQ9s-kjd1T_hu9O3l4d1bc5Yd8"
+	challenge := "qs2LYnoqYoXEzS6ZvFfUQF20KkFpKa_oV4_UVhdlj-g"
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{"Matching verifier", challenge, verifier, true},
+		{"Other verifier", challenge, verifier[1:] + "A", false},
+		{"Verifier sent as challenge", verifier, verifier, false},
+		{"Verifier too short", challenge, "short", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("verifyCodeChallenge() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOAuthService_RedirectURI(t *testing.T) {
+	oas := &oauthService{}
+	single := &OAuthClient{RedirectURIs: "https://app.test/callback"}
+	multiple := &OAuthClient{RedirectURIs: "https://app.test/callback http://localhost:8080/cb"}
+	tests := []struct {
+		name      string
+		client    *OAuthClient
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"Single registered URI by default", single, "", "https://app.test/callback", nil},
+		{"No default with multiple URIs", multiple, "", "", ErrRedirectURIInvalid},
+		{"Exact match", multiple, "http://localhost:8080/cb", "http://localhost:8080/cb", nil},
+		{"Prefix of a registered URI", single, "https://app.test/callback/evil", "", ErrRedirectURIInvalid},
+		{"Other host", single, "https://evil.test/callback", "", ErrRedirectURIInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := oas.RedirectURI(tt.client, tt.requested)
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("RedirectURI() = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOAuthService_ServiceAccountPolicy(t *testing.T) {
+	// Rejected registrations fail before the database is
+	// queried, so no database is needed here.
+	policy := WithServiceAccountPolicy([]uint{1}, []string{"users:read"})
+	tests := []struct {
+		name    string
+		cfgs    []OAuthServiceConfig
+		ownerID uint
+		scope   string
+		want    error
+	}{
+		{"Nobody allowed by default", nil, 1, "users:read", ErrServiceAccountNotAllowed},
+		{"Owner not allowed", []OAuthServiceConfig{policy}, 2, "users:read", ErrServiceAccountNotAllowed},
+		{"Scope not allowed", []OAuthServiceConfig{policy}, 1, "users:read users:write", ErrScopeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oas := NewOAuthService(nil, hash.KeyedHMAC{}, tt.cfgs...)
+			client := OAuthClient{Name: "cron", OwnerID: tt.ownerID, ServiceAccount: true, Scope: tt.scope}
+			if err := oas.RegisterClient(&client, true); err != tt.want {
+				t.Errorf("RegisterClient() = %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.test/callback", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://app.test/callback", false},
+		{"https://app.test/callback#fragment", false},
+		{"/callback", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := validRedirectURI(tt.uri); got != tt.want {
+				t.Errorf("validRedirectURI(%q) = %v; want %v", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ScopeOpenID is the scope requesting an OpenID Connect ID
+// token.
+const ScopeOpenID = "openid"
+
+// IDToken holds the claims of an OpenID Connect ID token.
+type IDToken struct {
+	Email string `json:"email,omitempty"`
+	// EmailVerified is read from the users table, and is false
+	// until the user verifies their email address.
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	jwt.StandardClaims
+}
+
+// GenerateIDToken creates an OpenID Connect ID token for the
+// client. ID tokens are signed with RS256, which every OpenID
+// Connect library must support, and live as long as the
+// client's access tokens.
+func (us *userService) GenerateIDToken(user *User, clientID, nonce string, authTime time.Time) (string, error) {
+	settings := us.settings()
+	now := time.Now()
+	claims := IDToken{
+		Email:             user.Email,
+		EmailVerified:     user.EmailVerified,
+		PreferredUsername: user.Username,
+		Nonce:             nonce,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    settings.issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(settings.lifetimes.lifetime(clientID, AccessToken)).Unix(),
+		},
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tokenString, err := settings.authentication.sign(token)
+	if err != nil {
+		return "", ErrSignedStringToken
+	}
+	return tokenString, nil
+}
+
+// PublicKey returns the key tokens are verified with.
+func (us *userService) PublicKey() *rsa.PublicKey {
+	return us.settings().authentication.publicKey
+}
+
+// PublicKeys returns the key tokens are signed with, followed
+// by the replaced keys that still verify tokens signed before
+// a rotation.
+func (us *userService) PublicKeys() []*rsa.PublicKey {
+	return us.settings().publicKeys()
+}
+
+// Issuer returns the "iss" claim of issued tokens.
+func (us *userService) Issuer() string {
+	return us.settings().issuer
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of the public
+// key, used as its "kid".
+func KeyID(pub *rsa.PublicKey) string {
+	jwk := fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(pub.N.Bytes()))
+	sum := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestUserService_GenerateIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			issuer:         "https://auth.example.com",
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+		},
+	}
+	user := &User{Email: "test@test.com", Username: "test", EmailVerified: true}
+	user.ID = 7
+	authTime := time.Now().Add(-time.Hour)
+
+	tokenString, err := us.GenerateIDToken(user, "client", "n-0S6", authTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims IDToken
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Method != jwt.SigningMethodRS256 {
+		t.Errorf("alg = %s; want RS256", token.Method.Alg())
+	}
+	if token.Header["kid"] != KeyID(&key.PublicKey) {
+		t.Errorf("kid = %v; want %s", token.Header["kid"], KeyID(&key.PublicKey))
+	}
+	switch {
+	case claims.Subject != "7",
+		claims.Audience != "client",
+		claims.Issuer != "https://auth.example.com",
+		claims.Nonce != "n-0S6",
+		claims.AuthTime != authTime.Unix(),
+		claims.Email != "test@test.com",
+		!claims.EmailVerified:
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// An unverified email address is claimed as such rather
+	// than left out.
+	user.EmailVerified = false
+	if tokenString, err = us.GenerateIDToken(user, "client", "", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	raw := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenString, raw, func(*jwt.Token) (interface{}, error) {
+		return &key.PublicKey, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if verified, ok := raw["email_verified"]; !ok || verified != false {
+		t.Errorf("email_verified = %v; want false", verified)
+	}
+}
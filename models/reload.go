@@ -0,0 +1,153 @@
+package models
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"time"
+)
+
+// tokenSettings are the settings tokens are issued and
+// verified with. They are never modified once in use; Reload
+// replaces them as a whole so every request sees a consistent
+// set.
+type tokenSettings struct {
+	authentication Authentication
+	validator      TokenValidator
+	issuer         string
+	lifetimes      tokenLifetimes
+	audiences      audiences
+	// previousKeys are the public keys replaced by Reload. They
+	// still verify the tokens signed before the rotation until
+	// the longest of those can have expired.
+	previousKeys []previousKey
+}
+
+// previousKey is a replaced public key and when the last token
+// it signed expires.
+type previousKey struct {
+	keyID     string
+	publicKey *rsa.PublicKey
+	until     time.Time
+}
+
+// settings returns the token settings currently in use.
+func (us *userService) settings() *tokenSettings {
+	us.tokensMu.RLock()
+	defer us.tokensMu.RUnlock()
+	return us.tokens
+}
+
+// WithKeys replaces the keys passed to NewUserService. It is
+// mostly useful with Reload to rotate the signing key.
+func WithKeys(signer crypto.Signer, public *rsa.PublicKey) UserServiceConfig {
+	return func(us *userService) {
+		us.tokens.authentication = Authentication{
+			signer:    signer,
+			publicKey: public,
+		}
+	}
+}
+
+// Reload applies cfgs on top of the settings in use and swaps
+// them in atomically. Only the keys, issuer, audiences, token
+// validation, token lifetimes and revocation cache TTL can be
+// reloaded; other options have no effect until a restart.
+// Requests already being served finish with the old settings.
+//
+// If the new signing key does not match the new public key, or
+// a required claim is unknown, nothing is changed. A replaced public key keeps verifying the
+// tokens it signed, selected by their "kid" header, for the
+// longest token lifetime plus the leeway, so rotating the key
+// does not sign everyone out.
+func (us *userService) Reload(cfgs ...UserServiceConfig) error {
+	us.tokensMu.Lock()
+	defer us.tokensMu.Unlock()
+
+	next := *us.tokens
+	staged := &userService{tokens: &next}
+	for _, cfg := range cfgs {
+		cfg(staged)
+	}
+	for _, name := range next.validator.Required {
+		if !knownClaim(name) {
+			return ErrClaimUnknown
+		}
+	}
+	a := next.authentication
+	if a.signer == nil || a.publicKey == nil || !a.publicKey.Equal(a.signer.Public()) {
+		return ErrSigningKeyMismatch
+	}
+	// Tokens signed with the replaced key were issued with the
+	// old lifetimes, which may be the longer ones.
+	lifetime := us.tokens.lifetimes.longest()
+	if l := next.lifetimes.longest(); l > lifetime {
+		lifetime = l
+	}
+	next.previousKeys = us.tokens.retainedKeys(a.publicKey, time.Now(), lifetime+next.validator.Leeway)
+
+	us.tokens = &next
+	if staged.versions != nil {
+		us.versions.setTTL(staged.versions.ttl)
+		us.activeSessions.setTTL(staged.versions.ttl)
+	}
+	return nil
+}
+
+// retainedKeys returns the previous keys of s still verifying
+// tokens at now, adding the current key of s if it is being
+// replaced by public. A key rotated back in is no longer a
+// previous key.
+func (s *tokenSettings) retainedKeys(public *rsa.PublicKey, now time.Time, lifetime time.Duration) []previousKey {
+	var keys []previousKey
+	for _, key := range s.previousKeys {
+		if now.Before(key.until) && !key.publicKey.Equal(public) {
+			keys = append(keys, key)
+		}
+	}
+	if current := s.authentication.publicKey; !current.Equal(public) {
+		keys = append(keys, previousKey{
+			keyID:     KeyID(current),
+			publicKey: current,
+			until:     now.Add(lifetime),
+		})
+	}
+	return keys
+}
+
+// verificationKey returns the public key a token with the
+// "kid" header kid is verified with. Tokens without one were
+// signed before key IDs were set and only verify with the
+// current key.
+func (s *tokenSettings) verificationKey(kid string) (*rsa.PublicKey, error) {
+	if kid == "" || kid == KeyID(s.authentication.publicKey) {
+		return s.authentication.publicKey, nil
+	}
+	now := time.Now()
+	for _, key := range s.previousKeys {
+		if key.keyID == kid && now.Before(key.until) {
+			return key.publicKey, nil
+		}
+	}
+	return nil, ErrWrongToken
+}
+
+// publicKeys returns the current key followed by the previous
+// keys still verifying tokens.
+func (s *tokenSettings) publicKeys() []*rsa.PublicKey {
+	keys := []*rsa.PublicKey{s.authentication.publicKey}
+	now := time.Now()
+	for _, key := range s.previousKeys {
+		if now.Before(key.until) {
+			keys = append(keys, key.publicKey)
+		}
+	}
+	return keys
+}
+
+// setTTL changes how long new entries are kept. Existing
+// entries keep their expiry.
+func (c *versionCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
@@ -0,0 +1,226 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserService_Reload(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: oldKey, publicKey: &oldKey.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		versions:       newVersionCache(time.Minute),
+		activeSessions: newSessionCache(time.Minute),
+	}
+	user := &User{}
+	user.ID = 1
+	if err := us.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	oldToken := user.Token
+
+	// A signing key that does not match the public key must
+	// leave every setting untouched.
+	err = us.Reload(WithIssuer("other"), WithKeys(newKey, &oldKey.PublicKey))
+	if err != ErrSigningKeyMismatch {
+		t.Fatalf("Reload() with mismatched keys = %v; want ErrSigningKeyMismatch", err)
+	}
+	if us.Issuer() != DefaultIssuer {
+		t.Errorf("Issuer() = %q after a rejected reload; want %q", us.Issuer(), DefaultIssuer)
+	}
+
+	err = us.Reload(
+		WithKeys(newKey, &newKey.PublicKey),
+		WithIssuer("https://auth.example.com"),
+		WithTokenLifetimes(TokenLifetimes{Access: time.Minute}, nil),
+		WithRevocationCache(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if us.PublicKey() != &newKey.PublicKey {
+		t.Error("PublicKey() should return the reloaded key")
+	}
+	if us.versions.ttl != time.Second || us.activeSessions.ttl != time.Second {
+		t.Errorf("revocation cache TTL = %v, %v; want 1s", us.versions.ttl, us.activeSessions.ttl)
+	}
+	// The replaced key still verifies the signature; only the
+	// reloaded issuer rejects the token.
+	if _, err := us.parseToken(oldToken); err != ErrTokenIssuerInvalid {
+		t.Errorf("parseToken() of a token signed with the replaced key = %v; want ErrTokenIssuerInvalid", err)
+	}
+	if keys := us.PublicKeys(); len(keys) != 2 || keys[0] != &newKey.PublicKey || keys[1] != &oldKey.PublicKey {
+		t.Errorf("PublicKeys() = %v; want the new key, then the replaced one", keys)
+	}
+
+	if err := us.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := us.parseToken(user.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "https://auth.example.com" {
+		t.Errorf("Issuer = %q; want the reloaded issuer", claims.Issuer)
+	}
+	if lifetime := time.Duration(claims.ExpiresAt-claims.IssuedAt) * time.Second; lifetime != time.Minute {
+		t.Errorf("token lifetime = %v; want 1m", lifetime)
+	}
+}
+
+func TestUserService_ReloadKeyRotation(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 3)
+	for i := range keys {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: keys[0], publicKey: &keys[0].PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		versions:       newVersionCache(time.Minute),
+		activeSessions: newSessionCache(time.Minute),
+	}
+	user := &User{}
+	user.ID = 1
+	if err := us.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	first := user.Token
+
+	if err := us.Reload(WithKeys(keys[1], &keys[1].PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+	previous := us.settings().previousKeys
+	if len(previous) != 1 || previous[0].keyID != KeyID(&keys[0].PublicKey) {
+		t.Fatalf("previousKeys = %+v; want the replaced key", previous)
+	}
+	if want := time.Now().Add(DefaultTokenLifetimes.Access); previous[0].until.Before(want.Add(-time.Minute)) || previous[0].until.After(want) {
+		t.Errorf("replaced key kept until %v; want for the longest token lifetime", previous[0].until)
+	}
+	if err := us.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	second := user.Token
+
+	// Rotating back to the first key keeps the second one
+	// verifying its tokens.
+	if err := us.Reload(WithKeys(keys[0], &keys[0].PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(us.settings().previousKeys); n != 1 {
+		t.Errorf("%d previous keys; want only the second key", n)
+	}
+	for name, token := range map[string]string{"first": first, "second": second} {
+		if _, err := us.parseToken(token); err != nil {
+			t.Errorf("parseToken() of the %s token = %v", name, err)
+		}
+	}
+
+	// Once the replaced keys expire, their tokens are rejected.
+	if err := us.Reload(WithKeys(keys[2], &keys[2].PublicKey)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range us.tokens.previousKeys {
+		us.tokens.previousKeys[i].until = time.Now().Add(-time.Second)
+	}
+	for name, token := range map[string]string{"first": first, "second": second} {
+		if _, err := us.parseToken(token); err != ErrWrongToken {
+			t.Errorf("parseToken() of the %s token after its key expired = %v; want ErrWrongToken", name, err)
+		}
+	}
+	if keys := us.PublicKeys(); len(keys) != 1 {
+		t.Errorf("PublicKeys() returned %d keys; want only the current one", len(keys))
+	}
+}
+
+func TestUserService_ReloadRequiredClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		versions:       newVersionCache(time.Minute),
+		activeSessions: newSessionCache(time.Minute),
+	}
+	if err := us.Reload(WithTokenValidation(0, []string{ClaimExpiresAt})); err != nil {
+		t.Fatal(err)
+	}
+	if got := us.settings().validator.Required; len(got) != 1 || got[0] != ClaimExpiresAt {
+		t.Fatalf("Required = %v; want the configured claims", got)
+	}
+	if err := us.Reload(WithTokenValidation(0, []string{"exp", "jit"})); err != ErrClaimUnknown {
+		t.Errorf("Reload() with an unknown required claim = %v; want ErrClaimUnknown", err)
+	}
+	// Removing required_claims from the configuration restores
+	// the defaults rather than keeping the previous list.
+	if err := us.Reload(WithTokenValidation(0, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := us.settings().validator.Required; len(got) != len(DefaultRequiredClaims) {
+		t.Errorf("Required = %v; want DefaultRequiredClaims", got)
+	}
+}
+
+func TestUserService_ReloadConcurrentUse(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		versions:       newVersionCache(time.Minute),
+		activeSessions: newSessionCache(time.Minute),
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				user := &User{}
+				user.ID = 1
+				if err := us.GenerateToken(user); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := us.Reload(WithTokenLifetimes(TokenLifetimes{Access: time.Duration(i+1) * time.Minute}, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestOAuthClient_GrantScope(t *testing.T) {
+	client := &OAuthClient{ServiceAccount: true, Scope: "reports:read billing:write"}
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"Everything by default", "", "reports:read billing:write", nil},
+		{"Subset", "reports:read", "reports:read", nil},
+		{"Extra spaces", "  billing:write   reports:read ", "billing:write reports:read", nil},
+		{"Not registered", "reports:read admin", "", ErrScopeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GrantScope(tt.requested)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("GrantScope() = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := (&OAuthClient{}).GrantScope(""); err != ErrNotServiceAccount {
+		t.Errorf("GrantScope() on a regular client = %v; want ErrNotServiceAccount", err)
+	}
+}
+
+func TestUserService_ServiceToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+	}
+	client := &OAuthClient{ClientID: "cron", ServiceAccount: true}
+
+	token, _, err := us.GenerateServiceToken(client, "reports:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := us.ServiceByToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ClientID != "cron" || service.Scope != "reports:read" || service.Claims.Subject != "cron" || service.Claims.Id == "" {
+		t.Errorf("ServiceByToken() = %+v", service)
+	}
+	if _, err := us.parseToken(token); err != ErrTokenKindInvalid {
+		t.Errorf("parseToken() of a service token = %v; want ErrTokenKindInvalid", err)
+	}
+
+	if _, _, err := us.GenerateServiceToken(&OAuthClient{ClientID: "web"}, ""); err != ErrNotServiceAccount {
+		t.Errorf("GenerateServiceToken() for a regular client = %v; want ErrNotServiceAccount", err)
+	}
+}
@@ -3,7 +3,15 @@ package models
 import (
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"crypto"
 	"crypto/rsa"
+	"golang-jwt-api/hash"
+	"golang-jwt-api/migrate"
+	"log"
+	"os"
+	"sync/atomic"
 )
 
 type ServicesConfig func(*Services) error
@@ -19,22 +27,77 @@ func WithGorm(dialect, connectionInfo string) ServicesConfig {
 	}
 }
 
+// WithLogMode turns SQL logging on or off. It can be switched
+// later with SetLogMode.
 func WithLogMode(mode bool) ServicesConfig {
 	return func(s *Services) error {
-		s.db.LogMode(mode)
+		s.sqlLog = &sqlLogger{out: gorm.Logger{LogWriter: log.New(os.Stdout, "\r\n", 0)}}
+		s.sqlLog.set(mode)
+		s.db.SetLogger(s.sqlLog)
+		s.db.LogMode(true)
 		return nil
 	}
 }
 
-func WithUser(pepper, hmacKey string,public *rsa.PublicKey, private *rsa.PrivateKey) ServicesConfig {
+// SetLogMode turns SQL logging on or off while the services
+// are in use. It has no effect unless WithLogMode was used.
+func (s *Services) SetLogMode(mode bool) {
+	if s.sqlLog != nil {
+		s.sqlLog.set(mode)
+	}
+}
+
+// sqlLogger forwards gorm's logs while it is enabled. gorm's
+// own log mode cannot be changed while queries are running.
+type sqlLogger struct {
+	enabled int32
+	out     gorm.Logger
+}
+
+func (l *sqlLogger) set(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&l.enabled, v)
+}
+
+func (l *sqlLogger) Print(v ...interface{}) {
+	if atomic.LoadInt32(&l.enabled) == 1 {
+		l.out.Print(v...)
+	}
+}
+
+// WithUser adds the user service. Tokens are signed with
+// signer, usually an *rsa.PrivateKey, and verified with public.
+func WithUser(pepper, hmacKey string,public *rsa.PublicKey, signer crypto.Signer, cfgs ...UserServiceConfig) ServicesConfig {
 	return func(s *Services) error {
-		s.User = NewUserService(s.db, pepper, hmacKey, private, public)
+		s.User = NewUserService(s.db, pepper, hmacKey, signer, public, cfgs...)
 		return nil
 	}
 }
 
 
 
+// WithOAuth adds the OAuth 2.0 authorization server. Client
+// secrets and authorization codes are stored as digests made
+// with hmac.
+func WithOAuth(hmac hash.KeyedHMAC, cfgs ...OAuthServiceConfig) ServicesConfig {
+	return func(s *Services) error {
+		s.OAuth = NewOAuthService(s.db, hmac, cfgs...)
+		return nil
+	}
+}
+
+// WithAPIKey adds personal API keys, stored as digests made
+// with hmac.
+func WithAPIKey(hmac hash.KeyedHMAC) ServicesConfig {
+	return func(s *Services) error {
+		s.APIKey = NewAPIKeyService(s.db, hmac)
+		return nil
+	}
+}
+
 func NewServices(cfgs ...ServicesConfig) (*Services, error) {
 	var s Services
 	for _, cfg := range cfgs {
@@ -47,7 +110,10 @@ func NewServices(cfgs ...ServicesConfig) (*Services, error) {
 
 type Services struct {
 	User    UserService
+	OAuth   OAuthService
+	APIKey  APIKeyService
 	db      *gorm.DB
+	sqlLog  *sqlLogger
 }
 
 // Closes the database connection
@@ -57,14 +123,25 @@ func (s *Services) Close() error {
 
 // DestructiveReset drops all tables and rebuilds them
 func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}).Error
+	err := s.db.DropTableIfExists(&User{}, &OAuthClient{}, &AuthorizationCode{}, &APIKey{}, &Session{}, &LoginAttempt{}, "schema_migrations").Error
 	if err != nil {
 		return err
 	}
-	return s.AutoMigrate()
+	return s.Migrate()
+}
+
+// Migrator returns the migrator of the services' schema, to
+// apply, roll back or list migrations.
+func (s *Services) Migrator() (*migrate.Migrator, error) {
+	return migrate.New(s.db, migrations)
 }
 
-// AutoMigrate will attempt to automatically migrate all tables
-func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}).Error
+// Migrate applies the pending schema migrations.
+func (s *Services) Migrate() error {
+	m, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	_, err = m.Up()
+	return err
 }
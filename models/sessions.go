@@ -0,0 +1,244 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang-jwt-api/rand"
+)
+
+const (
+	tokenIDBytes = 16
+	// sessionTouchInterval limits how often the last seen time
+	// of a session is written, so authenticated requests do not
+	// all turn into writes.
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a device a user signed in on. Each session is
+// linked to the ID ("jti") of the token issued at sign in, and
+// deleting it revokes that token.
+type Session struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index" json:"-"`
+	TokenID    string `gorm:"not null;type:varchar(64);unique_index" json:"-"`
+	UserAgent  string `gorm:"type:text"`
+	IP         string `gorm:"type:varchar(45)"`
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// Current marks the session of the token used to list
+	// sessions.
+	Current bool `gorm:"-"`
+}
+
+// SessionDB is used to interact with the sessions database.
+type SessionDB interface {
+	Create(session *Session) error
+	// ByTokenID returns the session linked to a token, or
+	// ErrNotFound if it was revoked.
+	ByTokenID(tokenID string) (*Session, error)
+	// ByUser returns the sessions of the user that have not
+	// expired, most recently seen first.
+	ByUser(userID uint) ([]Session, error)
+	// Touch records that the session was seen at t.
+	Touch(id uint, t time.Time) error
+	// Delete deletes the session if it belongs to the user and
+	// returns ErrNotFound otherwise.
+	Delete(userID, id uint) error
+	DeleteByUser(userID uint) error
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) ByTokenID(tokenID string) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("token_id = ?", tokenID), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) ByUser(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Touch(id uint, t time.Time) error {
+	return sg.db.Model(&Session{}).Where("id = ?", id).UpdateColumn("last_seen_at", t).Error
+}
+
+func (sg *sessionGorm) Delete(userID, id uint) error {
+	res := sg.db.Where("id = ? AND user_id = ?", id, userID).Delete(&Session{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (sg *sessionGorm) DeleteByUser(userID uint) error {
+	return sg.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+// ForDevice records the device a token is requested from in
+// the session started by Authenticate or StartSession.
+func ForDevice(userAgent, ip string) TokenOption {
+	return func(r *tokenRequest) {
+		r.userAgent = userAgent
+		r.ip = ip
+	}
+}
+
+// withTokenID sets the "jti" claim linking a token to its
+// session.
+func withTokenID(tokenID string) TokenOption {
+	return func(r *tokenRequest) {
+		r.tokenID = tokenID
+	}
+}
+
+// StartSession generates a token for the user and records the
+// session it belongs to.
+func (us *userService) StartSession(user *User, opts ...TokenOption) error {
+	tokenID, err := rand.String(tokenIDBytes)
+	if err != nil {
+		return err
+	}
+	if err := us.GenerateToken(user, append(opts, withTokenID(tokenID))...); err != nil {
+		return err
+	}
+	req := newTokenRequest(opts)
+	now := time.Now()
+	return us.sessions.Create(&Session{
+		UserID:     user.ID,
+		TokenID:    tokenID,
+		UserAgent:  req.userAgent,
+		IP:         req.ip,
+		LastSeenAt: now,
+		ExpiresAt:  user.TokenExpiresAt,
+	})
+}
+
+// checkSession rejects tokens whose session was revoked and
+// records when the session was last seen. Tokens issued
+// without a session, before sessions were introduced, are not
+// affected. Active sessions are cached for the revocation cache
+// TTL, so a session revoked on another instance may take that
+// long to be noticed.
+func (us *userService) checkSession(claims *JWTUser) error {
+	if claims.Id == "" || us.activeSessions.get(claims.Id) {
+		return nil
+	}
+	session, err := us.sessions.ByTokenID(claims.Id)
+	if err == ErrNotFound {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	us.activeSessions.set(claims.Id, session.ID)
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		return us.sessions.Touch(session.ID, now)
+	}
+	return nil
+}
+
+// Sessions returns the active sessions of the user.
+func (us *userService) Sessions(userID uint) ([]Session, error) {
+	return us.sessions.ByUser(userID)
+}
+
+// RevokeSession signs the user out of a single device.
+func (us *userService) RevokeSession(userID, id uint) error {
+	if err := us.sessions.Delete(userID, id); err != nil {
+		return err
+	}
+	us.activeSessions.forget(id)
+	return nil
+}
+
+// RevokeSessionByTokenID signs the user out of the session the
+// token with the given ID belongs to.
+func (us *userService) RevokeSessionByTokenID(userID uint, tokenID string) error {
+	session, err := us.sessions.ByTokenID(tokenID)
+	if err != nil {
+		return err
+	}
+	return us.RevokeSession(userID, session.ID)
+}
+
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		ttl:     ttl,
+		entries: make(map[string]sessionEntry),
+	}
+}
+
+// sessionCache remembers for a short time the sessions found
+// active, keyed by token ID, so checkSession does not query the
+// database on every request. It is safe for concurrent use.
+type sessionCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[string]sessionEntry
+}
+
+type sessionEntry struct {
+	id      uint
+	expires time.Time
+}
+
+func (c *sessionCache) get(tokenID string) bool {
+	c.mu.RLock()
+	entry, ok := c.entries[tokenID]
+	c.mu.RUnlock()
+	return ok && time.Now().Before(entry.expires)
+}
+
+func (c *sessionCache) set(tokenID string, id uint) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxVersionCacheEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[tokenID] = sessionEntry{id: id, expires: now.Add(c.ttl)}
+}
+
+// forget drops the session with the given ID, once revoked.
+func (c *sessionCache) forget(id uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.id == id {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *sessionCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// fakeSessionDB keeps sessions in a map keyed by token ID.
+type fakeSessionDB struct {
+	SessionDB
+	mu       sync.Mutex
+	sessions map[string]*Session
+	touched  int
+	lookups  int
+}
+
+func (f *fakeSessionDB) Create(session *Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session.ID = uint(len(f.sessions) + 1)
+	f.sessions[session.TokenID] = session
+	return nil
+}
+
+func (f *fakeSessionDB) ByTokenID(tokenID string) (*Session, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lookups++
+	session, ok := f.sessions[tokenID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+func (f *fakeSessionDB) Touch(id uint, t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.touched++
+	return nil
+}
+
+func (f *fakeSessionDB) Delete(userID, id uint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for tokenID, session := range f.sessions {
+		if session.ID == id && session.UserID == userID {
+			delete(f.sessions, tokenID)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (f *fakeSessionDB) DeleteByUser(userID uint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for tokenID, session := range f.sessions {
+		if session.UserID == userID {
+			delete(f.sessions, tokenID)
+		}
+	}
+	return nil
+}
+
+func TestUserService_Sessions(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := &fakeSessionDB{sessions: make(map[string]*Session)}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		sessions: sessions,
+		// Active sessions are not cached, so every check looks
+		// the session up.
+		activeSessions: newSessionCache(-time.Second),
+	}
+	user := &User{}
+	user.ID = 1
+
+	if err := us.StartSession(user, ForDevice("curl/7.68.0", "192.0.2.1")); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := us.parseToken(user.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, ok := sessions.sessions[claims.Id]
+	if !ok {
+		t.Fatalf("no session recorded for jti %q", claims.Id)
+	}
+	if session.UserID != 1 || session.UserAgent != "curl/7.68.0" || session.IP != "192.0.2.1" {
+		t.Errorf("session = %+v", session)
+	}
+	if !session.ExpiresAt.Equal(user.TokenExpiresAt) {
+		t.Errorf("ExpiresAt = %v; want %v", session.ExpiresAt, user.TokenExpiresAt)
+	}
+
+	if err := us.checkSession(claims); err != nil {
+		t.Errorf("checkSession() = %v; want nil", err)
+	}
+	if sessions.touched != 0 {
+		t.Error("a session seen just now should not be touched")
+	}
+	session.LastSeenAt = time.Now().Add(-2 * sessionTouchInterval)
+	if err := us.checkSession(claims); err != nil || sessions.touched != 1 {
+		t.Errorf("checkSession() = %v, touched %d times; want nil, 1", err, sessions.touched)
+	}
+
+	delete(sessions.sessions, claims.Id)
+	if err := us.checkSession(claims); err != ErrSessionRevoked {
+		t.Errorf("checkSession() of a revoked session = %v; want ErrSessionRevoked", err)
+	}
+	if err := us.checkSession(&JWTUser{ID: 1}); err != nil {
+		t.Errorf("checkSession() of a token without session = %v; want nil", err)
+	}
+}
+
+func TestUserService_ByClaimsRevokedSession(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := &fakeSessionDB{sessions: make(map[string]*Session)}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+		sessions:       sessions,
+		versions:       newVersionCache(time.Minute),
+		activeSessions: newSessionCache(time.Minute),
+	}
+	user := &User{}
+	user.ID = 1
+	if err := us.StartSession(user); err != nil {
+		t.Fatal(err)
+	}
+	// The token version is cached, so ByClaims needs no UserDB.
+	us.versions.set(1, 0)
+
+	if _, err := us.ByClaims(user.Token); err != nil {
+		t.Fatalf("ByClaims() = %v; want nil", err)
+	}
+	var id uint
+	for _, session := range sessions.sessions {
+		id = session.ID
+	}
+	if err := us.RevokeSession(1, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.ByClaims(user.Token); err != ErrSessionRevoked {
+		t.Errorf("ByClaims() after RevokeSession() = %v; want ErrSessionRevoked", err)
+	}
+}
+
+func TestUserService_SessionCache(t *testing.T) {
+	sessions := &fakeSessionDB{sessions: map[string]*Session{
+		"jti": {Model: gorm.Model{ID: 1}, UserID: 1, TokenID: "jti", LastSeenAt: time.Now()},
+	}}
+	us := &userService{sessions: sessions, activeSessions: newSessionCache(time.Minute)}
+	claims := &JWTUser{ID: 1}
+	claims.Id = "jti"
+
+	for i := 0; i < 3; i++ {
+		if err := us.checkSession(claims); err != nil {
+			t.Fatalf("checkSession() = %v; want nil", err)
+		}
+	}
+	if sessions.lookups != 1 {
+		t.Errorf("the session was looked up %d times; want once while cached", sessions.lookups)
+	}
+
+	if err := us.RevokeSession(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.checkSession(claims); err != ErrSessionRevoked {
+		t.Errorf("checkSession() after RevokeSession() = %v; want ErrSessionRevoked", err)
+	}
+}
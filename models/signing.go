@@ -0,0 +1,34 @@
+package models
+
+import (
+	cryptorand "crypto/rand"
+	"log"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// sign signs an RSA token with the service's signer, naming
+// its public key in the "kid" header so the token can still be
+// verified once the key is rotated. Going
+// through crypto.Signer instead of an *rsa.PrivateKey allows
+// the private key to live outside the process, behind any
+// signer producing PKCS#1 v1.5 signatures.
+func (a Authentication) sign(token *jwt.Token) (string, error) {
+	method, ok := token.Method.(*jwt.SigningMethodRSA)
+	if !ok {
+		return "", ErrSignedStringToken
+	}
+	token.Header["kid"] = KeyID(a.publicKey)
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", ErrSignedStringToken
+	}
+	h := method.Hash.New()
+	h.Write([]byte(signingString))
+	sig, err := a.signer.Sign(cryptorand.Reader, h.Sum(nil), method.Hash)
+	if err != nil {
+		log.Println(err)
+		return "", ErrSignedStringToken
+	}
+	return signingString + "." + jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+)
+
+// remoteSigner stands in for a key held by an agent or KMS: it
+// only exposes crypto.Signer.
+type remoteSigner struct {
+	key   *rsa.PrivateKey
+	calls int
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey { return &s.key.PublicKey }
+
+func (s *remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestAuthentication_SignWithSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &remoteSigner{key: key}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: signer, publicKey: &key.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+	}
+	user := &User{}
+	user.ID = 1
+	if err := us.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	if signer.calls != 1 {
+		t.Errorf("signer called %d times; want 1", signer.calls)
+	}
+	if _, err := us.parseToken(user.Token); err != nil {
+		t.Errorf("parseToken() of a token signed by the signer = %v", err)
+	}
+}
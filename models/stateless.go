@@ -0,0 +1,98 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultVersionCacheTTL = 30 * time.Second
+	// maxVersionCacheEntries bounds the cache; once exceeded,
+	// expired entries are swept on the next write.
+	maxVersionCacheEntries = 100000
+)
+
+// ByClaims is the stateless counterpart of ByToken. It trusts
+// the signed claims of the token and returns a User holding
+// only the ID, Username, Status and TokenVersion found in
+// them. The token version and the session of the token are
+// checked against in-process caches, so the database is only
+// hit once per user and session every revocation cache TTL.
+//
+// The returned user must not be saved, as it is missing all
+// other fields; load the full user with ByID first.
+func (us *userService) ByClaims(tokenString string) (*User, error) {
+	claims, err := us.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	version, ok := us.versions.get(claims.ID)
+	if !ok {
+		foundUser, err := us.ByID(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		version = foundUser.TokenVersion
+		us.versions.set(foundUser.ID, version)
+	}
+	if claims.Version != version {
+		return nil, ErrTokenRevoked
+	}
+	if err := us.checkSession(claims); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Username:     claims.Username,
+		Status:       claims.Status,
+		TokenVersion: claims.Version,
+		Claims:       claims,
+	}
+	user.ID = claims.ID
+	return user, nil
+}
+
+func newVersionCache(ttl time.Duration) *versionCache {
+	return &versionCache{
+		ttl:     ttl,
+		entries: make(map[uint]versionEntry),
+	}
+}
+
+// versionCache remembers the current token version of users
+// for a short time. It is safe for concurrent use.
+type versionCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[uint]versionEntry
+}
+
+type versionEntry struct {
+	version uint
+	expires time.Time
+}
+
+func (c *versionCache) get(id uint) (uint, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[id]
+	c.mu.RUnlock()
+	if !ok || time.Now().After(entry.expires) {
+		return 0, false
+	}
+	return entry.version, true
+}
+
+func (c *versionCache) set(id uint, version uint) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxVersionCacheEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[id] = versionEntry{version: version, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVersionCache(t *testing.T) {
+	c := newVersionCache(time.Minute)
+	if _, ok := c.get(1); ok {
+		t.Fatal("get() on an empty cache should miss")
+	}
+	c.set(1, 3)
+	if version, ok := c.get(1); !ok || version != 3 {
+		t.Errorf("get() = %d, %v; want 3, true", version, ok)
+	}
+
+	expired := newVersionCache(-time.Second)
+	expired.set(1, 3)
+	if _, ok := expired.get(1); ok {
+		t.Error("get() should miss for expired entries")
+	}
+}
+
+func TestVersionCacheConcurrentUse(t *testing.T) {
+	c := newVersionCache(time.Minute)
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.set(uint(i%10), uint(g))
+				c.get(uint(i % 10))
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func BenchmarkVersionCacheGet(b *testing.B) {
+	c := newVersionCache(time.Minute)
+	c.set(1, 1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.get(1)
+	}
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Names of the registered claims that can be listed in
+// TokenValidator.Required.
+const (
+	ClaimExpiresAt = "exp"
+	ClaimNotBefore = "nbf"
+	ClaimIssuedAt  = "iat"
+	ClaimIssuer    = "iss"
+	ClaimAudience  = "aud"
+	ClaimSubject   = "sub"
+)
+
+// DefaultRequiredClaims are the claims every token created
+// by GenerateToken carries.
+var DefaultRequiredClaims = []string{ClaimExpiresAt, ClaimIssuedAt, ClaimIssuer, ClaimSubject}
+
+// NewTokenValidator creates a TokenValidator accepting tokens
+// from the given issuer with no leeway and the default
+// required claims.
+func NewTokenValidator(issuer string) *TokenValidator {
+	return &TokenValidator{
+		Issuer:   issuer,
+		Kind:     AccessToken,
+		Required: DefaultRequiredClaims,
+		Now:      time.Now,
+	}
+}
+
+// TokenValidator validates the registered claims of a token
+// whose signature has already been verified.
+type TokenValidator struct {
+	// Issuer is the only accepted "iss" claim.
+	Issuer string
+	// Audience is the accepted "aud" claim. If it is empty,
+	// only tokens without an audience are accepted.
+	Audience string
+	// OtherAudiences lists the audiences accepted besides
+	// Audience.
+	OtherAudiences []string
+	// Kind is the accepted kind of token. Tokens issued before
+	// kinds were introduced are access tokens.
+	Kind TokenKind
+	// OtherKinds lists the kinds accepted besides Kind.
+	OtherKinds []TokenKind
+	// Leeway is the clock skew tolerated when checking the
+	// "exp", "nbf" and "iat" claims.
+	Leeway time.Duration
+	// Required lists the claims a token must carry. Claims that
+	// are not required are still checked when present.
+	Required []string
+	// Now returns the current time and can be replaced in
+	// tests.
+	Now func() time.Time
+}
+
+// Validate checks the claims in the following order: required
+// claims, "exp", "nbf", "iat", "iss", "aud", the kind of token
+// and "sub". The first failing rule decides the returned
+// error. On success claims.ID holds the ID of the user the
+// token was issued to, except for service tokens, whose
+// subject is the client ID.
+func (v *TokenValidator) Validate(claims *JWTUser) error {
+	for _, name := range v.Required {
+		if !claimPresent(claims, name) {
+			return ErrTokenClaimMissing
+		}
+	}
+
+	now := v.Now().Unix()
+	leeway := int64(v.Leeway / time.Second)
+
+	if claims.ExpiresAt != 0 && now > claims.ExpiresAt+leeway {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now+leeway < claims.NotBefore {
+		return ErrTokenNotValidYet
+	}
+	if claims.IssuedAt != 0 && now+leeway < claims.IssuedAt {
+		return ErrTokenIssuedInFuture
+	}
+	if claims.Issuer != v.Issuer {
+		return ErrTokenIssuerInvalid
+	}
+	if !v.acceptsAudience(claims.Audience) {
+		return ErrTokenAudienceInvalid
+	}
+	kind := claims.Kind
+	if kind == "" {
+		kind = AccessToken
+	}
+	if !v.acceptsKind(kind) {
+		return ErrTokenKindInvalid
+	}
+	if kind == ServiceToken {
+		if claims.Subject == "" || claims.Subject != claims.ClientID || claims.ID != 0 {
+			return ErrTokenSubjectInvalid
+		}
+		return nil
+	}
+	return validateSubject(claims)
+}
+
+func (v *TokenValidator) acceptsKind(kind TokenKind) bool {
+	if kind == v.Kind {
+		return true
+	}
+	for _, other := range v.OtherKinds {
+		if kind == other {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *TokenValidator) acceptsAudience(audience string) bool {
+	if audience == v.Audience {
+		return true
+	}
+	for _, other := range v.OtherAudiences {
+		if audience == other {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSubject makes sure the token identifies a user. The
+// "sub" claim holds the user ID; tokens issued before it was
+// added only carry the "id" claim. If both are present they
+// must agree.
+func validateSubject(claims *JWTUser) error {
+	if claims.Subject == "" {
+		if claims.ID < 1 {
+			return ErrTokenSubjectInvalid
+		}
+		return nil
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return ErrTokenSubjectInvalid
+	}
+	if claims.ID != 0 && uint(id) != claims.ID {
+		return ErrTokenSubjectInvalid
+	}
+	claims.ID = uint(id)
+	return nil
+}
+
+// knownClaim reports whether claimPresent can check the
+// named claim.
+func knownClaim(name string) bool {
+	switch name {
+	case ClaimExpiresAt, ClaimNotBefore, ClaimIssuedAt, ClaimIssuer, ClaimAudience, ClaimSubject:
+		return true
+	}
+	return false
+}
+
+// claimPresent reports whether the named claim is set. Unknown
+// claim names are never present.
+func claimPresent(claims *JWTUser, name string) bool {
+	switch name {
+	case ClaimExpiresAt:
+		return claims.ExpiresAt != 0
+	case ClaimNotBefore:
+		return claims.NotBefore != 0
+	case ClaimIssuedAt:
+		return claims.IssuedAt != 0
+	case ClaimIssuer:
+		return claims.Issuer != ""
+	case ClaimAudience:
+		return claims.Audience != ""
+	case ClaimSubject:
+		return claims.Subject != "" || claims.ID != 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestTokenValidator_Validate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	unix := now.Unix()
+	valid := func() JWTUser {
+		return JWTUser{
+			ID: 1,
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: unix + 60,
+				IssuedAt:  unix - 60,
+				Issuer:    "issuer",
+				Subject:   "1",
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		claims   func(c *JWTUser)
+		leeway   time.Duration
+		audience string
+		required []string
+		want     error
+	}{
+		{"Valid token", func(c *JWTUser) {}, 0, "", nil, nil},
+		{"Legacy token without sub", func(c *JWTUser) { c.Subject = "" }, 0, "", nil, nil},
+		{"Expired token", func(c *JWTUser) { c.ExpiresAt = unix - 1 }, 0, "", nil, ErrTokenExpired},
+		{"Expired token within leeway", func(c *JWTUser) { c.ExpiresAt = unix - 10 }, 30 * time.Second, "", nil, nil},
+		{"Expired token beyond leeway", func(c *JWTUser) { c.ExpiresAt = unix - 31 }, 30 * time.Second, "", nil, ErrTokenExpired},
+		{"Token not valid yet", func(c *JWTUser) { c.NotBefore = unix + 1 }, 0, "", nil, ErrTokenNotValidYet},
+		{"Token not valid yet within leeway", func(c *JWTUser) { c.NotBefore = unix + 10 }, 30 * time.Second, "", nil, nil},
+		{"Token issued in the future", func(c *JWTUser) { c.IssuedAt = unix + 1 }, 0, "", nil, ErrTokenIssuedInFuture},
+		{"Token issued in the future within leeway", func(c *JWTUser) { c.IssuedAt = unix + 10 }, 30 * time.Second, "", nil, nil},
+		{"Wrong issuer", func(c *JWTUser) { c.Issuer = "other" }, 0, "", nil, ErrTokenIssuerInvalid},
+		{"Missing audience when configured", func(c *JWTUser) {}, 0, "billing", nil, ErrTokenAudienceInvalid},
+		{"Wrong audience", func(c *JWTUser) { c.Audience = "reports" }, 0, "billing", nil, ErrTokenAudienceInvalid},
+		{"Matching audience", func(c *JWTUser) { c.Audience = "billing" }, 0, "billing", nil, nil},
+		{"Audience when none is configured", func(c *JWTUser) { c.Audience = "billing" }, 0, "", nil, ErrTokenAudienceInvalid},
+		{"Access token", func(c *JWTUser) { c.Kind = AccessToken }, 0, "", nil, nil},
+		{"MFA challenge token", func(c *JWTUser) { c.Kind = MFAChallengeToken }, 0, "", nil, ErrTokenKindInvalid},
+		{"Email link token", func(c *JWTUser) { c.Kind = EmailLinkToken }, 0, "", nil, ErrTokenKindInvalid},
+		{"Service token", func(c *JWTUser) { c.Kind = ServiceToken }, 0, "", nil, ErrTokenKindInvalid},
+		{"OAuth token", func(c *JWTUser) { c.Kind = OAuthToken }, 0, "", nil, ErrTokenKindInvalid},
+		{"Non numeric subject", func(c *JWTUser) { c.Subject = "abc" }, 0, "", nil, ErrTokenSubjectInvalid},
+		{"Zero subject", func(c *JWTUser) { c.Subject = "0"; c.ID = 0 }, 0, "", nil, ErrTokenSubjectInvalid},
+		{"Subject not matching id", func(c *JWTUser) { c.Subject = "2" }, 0, "", nil, ErrTokenSubjectInvalid},
+		{"No subject at all", func(c *JWTUser) { c.Subject = ""; c.ID = 0 }, 0, "", []string{}, ErrTokenSubjectInvalid},
+		{"Missing required exp", func(c *JWTUser) { c.ExpiresAt = 0 }, 0, "", nil, ErrTokenClaimMissing},
+		{"Missing required iat", func(c *JWTUser) { c.IssuedAt = 0 }, 0, "", nil, ErrTokenClaimMissing},
+		{"Missing required iss", func(c *JWTUser) { c.Issuer = "" }, 0, "", nil, ErrTokenClaimMissing},
+		{"Missing required sub", func(c *JWTUser) { c.Subject = ""; c.ID = 0 }, 0, "", nil, ErrTokenClaimMissing},
+		{"Missing required nbf", func(c *JWTUser) {}, 0, "", []string{ClaimNotBefore}, ErrTokenClaimMissing},
+		{"Missing required aud", func(c *JWTUser) {}, 0, "", []string{ClaimAudience}, ErrTokenClaimMissing},
+		{"Unknown required claim", func(c *JWTUser) {}, 0, "", []string{"jti"}, ErrTokenClaimMissing},
+		{"Missing exp when not required", func(c *JWTUser) { c.ExpiresAt = 0 }, 0, "", []string{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewTokenValidator("issuer")
+			v.Now = func() time.Time { return now }
+			v.Leeway = tt.leeway
+			v.Audience = tt.audience
+			if tt.required != nil {
+				v.Required = tt.required
+			}
+			claims := valid()
+			tt.claims(&claims)
+			if err := v.Validate(&claims); err != tt.want {
+				t.Errorf("Validate() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenValidator_OtherAudiences(t *testing.T) {
+	v := NewTokenValidator("issuer")
+	v.Audience = "api"
+	v.OtherAudiences = []string{"billing"}
+	claims := JWTUser{ID: 1}
+	claims.Issuer = "issuer"
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	claims.IssuedAt = time.Now().Unix()
+	for audience, want := range map[string]error{"api": nil, "billing": nil, "reports": ErrTokenAudienceInvalid, "": ErrTokenAudienceInvalid} {
+		claims.Audience = audience
+		if err := v.Validate(&claims); err != want {
+			t.Errorf("Validate() of a token for %q = %v; want %v", audience, err, want)
+		}
+	}
+}
+
+func TestTokenValidator_OtherKinds(t *testing.T) {
+	v := NewTokenValidator("issuer")
+	v.OtherKinds = []TokenKind{OAuthToken}
+	claims := JWTUser{ID: 1, Kind: OAuthToken}
+	claims.Issuer = "issuer"
+	claims.ExpiresAt = time.Now().Add(time.Minute).Unix()
+	claims.IssuedAt = time.Now().Unix()
+	if err := v.Validate(&claims); err != nil {
+		t.Errorf("Validate() of an OAuth token = %v; want nil", err)
+	}
+	claims.Kind = ServiceToken
+	if err := v.Validate(&claims); err != ErrTokenKindInvalid {
+		t.Errorf("Validate() of a service token = %v; want ErrTokenKindInvalid", err)
+	}
+}
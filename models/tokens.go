@@ -0,0 +1,265 @@
+package models
+
+import (
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TokenKind tells apart the tokens issued by the user service.
+// Only access and OAuth tokens are accepted by ByToken, and only
+// service tokens by ServiceByToken.
+type TokenKind string
+
+const (
+	AccessToken       TokenKind = "access"
+	MFAChallengeToken TokenKind = "mfa_challenge"
+	EmailLinkToken    TokenKind = "email_link"
+	// ServiceToken is issued to service accounts through the
+	// client credentials grant. Its "sub" claim is the client
+	// ID instead of a user ID.
+	ServiceToken TokenKind = "service"
+	// OAuthToken is issued to third-party clients through the
+	// authorization code grant. It identifies the user like an
+	// access token, and lives as long, but only grants the scope
+	// in its "scope" claim.
+	OAuthToken TokenKind = "oauth"
+)
+
+// userTokenKinds are the kinds of token identifying a user that
+// ByToken, ByClaims and Introspect accept besides access tokens.
+var userTokenKinds = []TokenKind{OAuthToken}
+
+// TokenLifetimes holds how long each kind of token is valid.
+type TokenLifetimes struct {
+	Access       time.Duration
+	MFAChallenge time.Duration
+	EmailLink    time.Duration
+	Service      time.Duration
+}
+
+// DefaultTokenLifetimes are used for every lifetime that is
+// not configured.
+var DefaultTokenLifetimes = TokenLifetimes{
+	Access:       72 * time.Hour,
+	MFAChallenge: 5 * time.Minute,
+	EmailLink:    24 * time.Hour,
+	Service:      time.Hour,
+}
+
+// orDefaults replaces zero lifetimes with those in defaults.
+func (l TokenLifetimes) orDefaults(defaults TokenLifetimes) TokenLifetimes {
+	if l.Access == 0 {
+		l.Access = defaults.Access
+	}
+	if l.MFAChallenge == 0 {
+		l.MFAChallenge = defaults.MFAChallenge
+	}
+	if l.EmailLink == 0 {
+		l.EmailLink = defaults.EmailLink
+	}
+	if l.Service == 0 {
+		l.Service = defaults.Service
+	}
+	return l
+}
+
+func (l TokenLifetimes) byKind(kind TokenKind) time.Duration {
+	switch kind {
+	case MFAChallengeToken:
+		return l.MFAChallenge
+	case EmailLinkToken:
+		return l.EmailLink
+	case ServiceToken:
+		return l.Service
+	default:
+		return l.Access
+	}
+}
+
+// tokenLifetimes resolves the lifetime of a token from the
+// requesting client's overrides and the defaults.
+type tokenLifetimes struct {
+	defaults TokenLifetimes
+	clients  map[string]TokenLifetimes
+}
+
+func (l tokenLifetimes) lifetime(client string, kind TokenKind) time.Duration {
+	if overrides, ok := l.clients[client]; ok {
+		return overrides.orDefaults(l.defaults).byKind(kind)
+	}
+	return l.defaults.byKind(kind)
+}
+
+// longest returns the longest lifetime of any kind of token
+// for any client.
+func (l tokenLifetimes) longest() time.Duration {
+	var longest time.Duration
+	check := func(lifetimes TokenLifetimes) {
+		for _, d := range []time.Duration{lifetimes.Access, lifetimes.MFAChallenge, lifetimes.EmailLink, lifetimes.Service} {
+			if d > longest {
+				longest = d
+			}
+		}
+	}
+	check(l.defaults)
+	for _, overrides := range l.clients {
+		check(overrides.orDefaults(l.defaults))
+	}
+	return longest
+}
+
+// TokenOption customizes a token created by GenerateToken or
+// one of the methods that call it.
+type TokenOption func(*tokenRequest)
+
+// tokenRequest collects the TokenOptions passed when a token
+// is requested.
+type tokenRequest struct {
+	audience string
+	client   string
+	kind     TokenKind
+	scope    string
+	tokenID  string
+	// userAgent and ip describe the device of the session.
+	userAgent string
+	ip        string
+}
+
+// ForAudience requests a token for the given audience, which
+// must be one of the service's registered audiences. Without
+// it the token is issued for the service's own audience.
+func ForAudience(audience string) TokenOption {
+	return func(r *tokenRequest) {
+		r.audience = audience
+	}
+}
+
+// ForClient identifies the client requesting the token, so
+// its configured lifetimes are used.
+func ForClient(client string) TokenOption {
+	return func(r *tokenRequest) {
+		r.client = client
+	}
+}
+
+// ForScope records the space separated OAuth scopes granted
+// to the client in the "scope" claim.
+func ForScope(scope string) TokenOption {
+	return func(r *tokenRequest) {
+		r.scope = scope
+	}
+}
+
+// OfKind requests a token of the given kind instead of an
+// access token.
+func OfKind(kind TokenKind) TokenOption {
+	return func(r *tokenRequest) {
+		r.kind = kind
+	}
+}
+
+func newTokenRequest(opts []TokenOption) tokenRequest {
+	r := tokenRequest{kind: AccessToken}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// audiences holds the audience identifying this service and
+// the other audiences clients may request tokens for.
+type audiences struct {
+	own        string
+	registered map[string]bool
+	// others lists the registered audiences, for validators.
+	others []string
+}
+
+// resolve returns the audience to put in a token, defaulting
+// to the service's own audience.
+func (a audiences) resolve(requested string) (string, error) {
+	if requested == "" || requested == a.own {
+		return a.own, nil
+	}
+	if !a.registered[requested] {
+		return "", ErrAudienceNotRegistered
+	}
+	return requested, nil
+}
+
+// Introspect runs the same validation as ByToken and returns
+// the claims of the token along with the user it was issued
+// to. Unlike ByToken it accepts tokens issued for any of the
+// registered audiences, since it answers on behalf of them.
+func (us *userService) Introspect(tokenString string) (*JWTUser, *User, error) {
+	settings := us.settings()
+	validator := settings.userValidator()
+	validator.OtherAudiences = settings.audiences.others
+	claims, err := settings.parseToken(tokenString, &validator)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := us.userForClaims(claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, user, nil
+}
+
+// userForClaims loads the user a validated token was issued
+// to and makes sure neither the token nor its session was
+// revoked since.
+func (us *userService) userForClaims(claims *JWTUser) (*User, error) {
+	foundUser, err := us.ByID(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Version != foundUser.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+	if err := us.checkSession(claims); err != nil {
+		return nil, err
+	}
+	foundUser.Claims = claims
+	return foundUser, nil
+}
+
+// parseToken verifies the signature of an RS512 signed token
+// identifying a user and validates its claims with the
+// service's TokenValidator.
+func (us *userService) parseToken(tokenString string) (*JWTUser, error) {
+	settings := us.settings()
+	validator := settings.userValidator()
+	return settings.parseToken(tokenString, &validator)
+}
+
+// userValidator returns the validator of s, also accepting the
+// OAuth tokens issued to third-party clients for a user.
+func (s *tokenSettings) userValidator() TokenValidator {
+	validator := s.validator
+	validator.OtherKinds = userTokenKinds
+	return validator
+}
+
+// parseToken verifies the signature of a token with the public
+// key of s and validates its claims with validator.
+func (s *tokenSettings) parseToken(tokenString string, validator *TokenValidator) (*JWTUser, error) {
+	jwtUser := JWTUser{}
+	parser := jwt.Parser{
+		ValidMethods:         []string{jwt.SigningMethodRS512.Alg()},
+		SkipClaimsValidation: true,
+	}
+	_, err := parser.ParseWithClaims(tokenString, &jwtUser, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.verificationKey(kid)
+	})
+	if err != nil {
+		return nil, ErrWrongToken
+	}
+
+	if err := validator.Validate(&jwtUser); err != nil {
+		return nil, err
+	}
+	return &jwtUser, nil
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestAudiences_Resolve(t *testing.T) {
+	a := audiences{own: "api", registered: map[string]bool{"billing": true}}
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"No audience requested", "", "api", nil},
+		{"Own audience requested", "api", "api", nil},
+		{"Registered audience requested", "billing", "billing", nil},
+		{"Unregistered audience requested", "reports", "", ErrAudienceNotRegistered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.resolve(tt.requested)
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("resolve(%q) = %q, %v; want %q, %v", tt.requested, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenLifetimes_Lifetime(t *testing.T) {
+	l := tokenLifetimes{
+		defaults: TokenLifetimes{Access: time.Hour, MFAChallenge: 5 * time.Minute, EmailLink: 24 * time.Hour},
+		clients: map[string]TokenLifetimes{
+			"cli": {Access: 15 * time.Minute},
+		},
+	}
+	tests := []struct {
+		name   string
+		client string
+		kind   TokenKind
+		want   time.Duration
+	}{
+		{"Access token without client", "", AccessToken, time.Hour},
+		{"Access token for unknown client", "web", AccessToken, time.Hour},
+		{"Access token for client with override", "cli", AccessToken, 15 * time.Minute},
+		{"MFA token for client without MFA override", "cli", MFAChallengeToken, 5 * time.Minute},
+		{"Email link token", "", EmailLinkToken, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.lifetime(tt.client, tt.kind); got != tt.want {
+				t.Errorf("lifetime(%q, %q) = %v; want %v", tt.client, tt.kind, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserService_DownstreamAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us := &userService{
+		tokens: &tokenSettings{
+			authentication: Authentication{signer: key, publicKey: &key.PublicKey},
+			issuer:         DefaultIssuer,
+			lifetimes:      tokenLifetimes{defaults: DefaultTokenLifetimes},
+			validator:      *NewTokenValidator(DefaultIssuer),
+		},
+	}
+	WithAudiences("", []string{"billing"})(us)
+	user := &User{}
+	user.ID = 1
+	if err := us.GenerateToken(user, ForAudience("billing")); err != nil {
+		t.Fatal(err)
+	}
+	// A token for a downstream service is not accepted here,
+	// even when this service has no audience of its own.
+	if _, err := us.parseToken(user.Token); err != ErrTokenAudienceInvalid {
+		t.Errorf("parseToken() of a token for billing = %v; want ErrTokenAudienceInvalid", err)
+	}
+	if _, err := us.settings().parseToken(user.Token, &TokenValidator{
+		Issuer:         DefaultIssuer,
+		Kind:           AccessToken,
+		OtherAudiences: us.settings().audiences.others,
+		Now:            time.Now,
+	}); err != nil {
+		t.Errorf("parseToken() with the registered audiences = %v; want nil", err)
+	}
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// testUserDB is the conformance suite every UserDB must pass.
+// newUserDB may return a store shared between calls, so users
+// get names no other test uses.
+func testUserDB(t *testing.T, newUserDB func(t *testing.T) UserDB) {
+	t.Run("Create sets the ID and timestamps", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		if user.ID == 0 || user.CreatedAt.IsZero() || user.UpdatedAt.IsZero() {
+			t.Errorf("Create() left ID %d, CreatedAt %v, UpdatedAt %v unset", user.ID, user.CreatedAt, user.UpdatedAt)
+		}
+		other := newConformanceUser()
+		if err := udb.Create(other); err != nil {
+			t.Fatal(err)
+		}
+		if other.ID == user.ID {
+			t.Error("Create() should give every user its own ID")
+		}
+	})
+
+	t.Run("Lookups", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		lookups := map[string]func() (*User, error){
+			"ByID":       func() (*User, error) { return udb.ByID(user.ID) },
+			"ByEmail":    func() (*User, error) { return udb.ByEmail(user.Email) },
+			"ByUsername": func() (*User, error) { return udb.ByUsername(user.Username) },
+		}
+		for name, lookup := range lookups {
+			found, err := lookup()
+			if err != nil {
+				t.Errorf("%s() = %v", name, err)
+				continue
+			}
+			if found.ID != user.ID || found.Username != user.Username || found.PasswordHash != user.PasswordHash {
+				t.Errorf("%s() = %+v; want %+v", name, found, user)
+			}
+			if found.Password != "" {
+				t.Errorf("%s() returned the password, which must not be stored", name)
+			}
+		}
+	})
+
+	t.Run("Missing users are not found", func(t *testing.T) {
+		udb := newUserDB(t)
+		missing := newConformanceUser()
+		lookups := map[string]func() (*User, error){
+			"ByID":       func() (*User, error) { return udb.ByID(1 << 30) },
+			"ByEmail":    func() (*User, error) { return udb.ByEmail(missing.Email) },
+			"ByUsername": func() (*User, error) { return udb.ByUsername(missing.Username) },
+		}
+		for name, lookup := range lookups {
+			// Look up twice, so a store that remembers misses is
+			// checked on the remembered one too.
+			for i := 0; i < 2; i++ {
+				user, err := lookup()
+				if err != ErrNotFound {
+					t.Errorf("%s() = %v; want ErrNotFound", name, err)
+				}
+				if user == nil {
+					t.Errorf("%s() returned a nil user; want an empty one", name)
+				}
+			}
+		}
+	})
+
+	t.Run("Usernames and emails are unique", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		sameUsername := newConformanceUser()
+		sameUsername.Username = user.Username
+		if err := udb.Create(sameUsername); err != ErrUsernameTaken {
+			t.Errorf("Create() with a taken username = %v; want ErrUsernameTaken", err)
+		}
+		sameEmail := newConformanceUser()
+		sameEmail.Email = user.Email
+		if err := udb.Create(sameEmail); err != ErrEmailTaken {
+			t.Errorf("Create() with a taken email = %v; want ErrEmailTaken", err)
+		}
+
+		other := newConformanceUser()
+		if err := udb.Create(other); err != nil {
+			t.Fatal(err)
+		}
+		other.Username = user.Username
+		if err := udb.Update(other); err != ErrUsernameTaken {
+			t.Errorf("Update() to a taken username = %v; want ErrUsernameTaken", err)
+		}
+		other.Username = newConformanceUser().Username
+		other.Email = user.Email
+		if err := udb.Update(other); err != ErrEmailTaken {
+			t.Errorf("Update() to a taken email = %v; want ErrEmailTaken", err)
+		}
+		if found, err := udb.ByUsername(user.Username); err != nil || found.ID != user.ID {
+			t.Errorf("ByUsername() = %+v, %v; want the first user", found, err)
+		}
+	})
+
+	t.Run("Update saves every field", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		renamed := newConformanceUser()
+		user.Username = renamed.Username
+		user.TokenVersion = 3
+		user.Status = Inactive
+		if err := udb.Update(user); err != nil {
+			t.Fatal(err)
+		}
+		found, err := udb.ByID(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Username != renamed.Username || found.TokenVersion != 3 || found.Status != Inactive {
+			t.Errorf("ByID() after Update() = %+v", found)
+		}
+	})
+
+	t.Run("Returned users are copies", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		user.TokenVersion = 7
+		found, err := udb.ByID(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found.TokenVersion = 9
+		if again, _ := udb.ByID(user.ID); again.TokenVersion != 0 {
+			t.Errorf("TokenVersion = %d; changes to users must only be stored by Update", again.TokenVersion)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		udb := newUserDB(t)
+		user := newConformanceUser()
+		if err := udb.Create(user); err != nil {
+			t.Fatal(err)
+		}
+		if err := udb.Delete(user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := udb.ByID(user.ID); err != ErrNotFound {
+			t.Errorf("ByID() of a deleted user = %v; want ErrNotFound", err)
+		}
+		if _, err := udb.ByUsername(user.Username); err != ErrNotFound {
+			t.Errorf("ByUsername() of a deleted user = %v; want ErrNotFound", err)
+		}
+		// Users are soft deleted, so their username stays taken.
+		again := newConformanceUser()
+		again.Username = user.Username
+		if err := udb.Create(again); err != ErrUsernameTaken {
+			t.Errorf("Create() with the username of a deleted user = %v; want ErrUsernameTaken", err)
+		}
+		if err := udb.Delete(1 << 30); err != nil {
+			t.Errorf("Delete() of a missing user = %v; want nil", err)
+		}
+	})
+}
+
+var conformanceUsers uint64
+
+// newConformanceUser returns a valid user with a username and
+// email address no other user has.
+func newConformanceUser() *User {
+	n := atomic.AddUint64(&conformanceUsers, 1)
+	return &User{
+		Username:     fmt.Sprintf("conformance%d", n),
+		Email:        fmt.Sprintf("conformance%d@example.com", n),
+		Password:     "12345678",
+		PasswordHash: "hash",
+		Status:       Active,
+	}
+}
+
+func TestMemoryUserDB(t *testing.T) {
+	testUserDB(t, func(t *testing.T) UserDB { return NewMemoryUserDB() })
+}
+
+// TestUserCache_Conformance runs the conformance suite through the user
+// cache, so cached lookups behave like the UserDB it wraps.
+func TestUserCache_Conformance(t *testing.T) {
+	testUserDB(t, func(t *testing.T) UserDB {
+		return newUserCache(NewMemoryUserDB(), 100, time.Minute)
+	})
+}
+
+func TestMemoryUserDB_ConcurrentUse(t *testing.T) {
+	udb := NewMemoryUserDB()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				user := newConformanceUser()
+				if err := udb.Create(user); err != nil {
+					t.Error(err)
+					return
+				}
+				udb.ByUsername(user.Username)
+				user.TokenVersion++
+				udb.Update(user)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestUserGorm runs the conformance suite against the users
+// table, in SQLite, and in the database configured by
+// $APP_TEST_CONFIG if any.
+func TestUserGorm(t *testing.T) {
+	t.Run("SQLite", func(t *testing.T) {
+		testUserDB(t, func(t *testing.T) UserDB {
+			db, err := gorm.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := (&Services{db: db}).Migrate(); err != nil {
+				t.Fatal(err)
+			}
+			return &userGorm{db}
+		})
+	})
+	if mockDb != nil {
+		t.Run(mockDb.Dialect().GetName(), func(t *testing.T) {
+			testUserDB(t, func(t *testing.T) UserDB { return &userGorm{mockDb} })
+		})
+	}
+}
+
+// TestUniqueViolation covers the drivers the conformance suite
+// only reaches with $APP_TEST_CONFIG.
+func TestUniqueViolation(t *testing.T) {
+	other := errors.New("boom")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"MySQL username", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'bob' for key 'users.uix_users_username'"}, ErrUsernameTaken},
+		{"MySQL email quoting a username", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'username@example.com' for key 'uix_users_email'"}, ErrEmailTaken},
+		{"PostgreSQL email", &pq.Error{Code: "23505", Constraint: "uix_users_email"}, ErrEmailTaken},
+		{"PostgreSQL other violation", &pq.Error{Code: "23502", Constraint: "users_username_not_null"}, nil},
+		{"Other error", other, other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == nil {
+				want = tt.err
+			}
+			if got := uniqueViolation(tt.err); got != want {
+				t.Errorf("uniqueViolation() = %v; want %v", got, want)
+			}
+		})
+	}
+}
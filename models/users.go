@@ -7,9 +7,17 @@ import (
 	"regexp"
 	"strings"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"crypto"
 	"crypto/rsa"
 	"time"
 	"database/sql/driver"
+	"strconv"
+	"sync"
+	"fmt"
+	"log"
 )
 
 type StatusType string
@@ -20,9 +28,39 @@ const (
 	Pending StatusType = "pending"
 )
 
-func (u *StatusType) Scan(value interface{}) error { *u = StatusType(value.([]byte)); return nil }
+// Scan reads a status stored as text, which drivers return
+// either as a string or as bytes.
+func (u *StatusType) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*u = StatusType(v)
+	case string:
+		*u = StatusType(v)
+	case nil:
+		*u = ""
+	default:
+		return fmt.Errorf("models: cannot scan %T into StatusType", value)
+	}
+	return nil
+}
 func (u StatusType) Value() (driver.Value, error)  { return string(u), nil }
 
+// IsActive reports whether the user may use the service.
+// Users created without a status are active.
+func (u StatusType) IsActive() bool {
+	return u == Active || u == ""
+}
+
+// valid reports whether the status is one of the known ones.
+// Users created without a status have the empty one.
+func (u StatusType) valid() bool {
+	switch u {
+	case "", Active, Inactive, Pending:
+		return true
+	}
+	return false
+}
+
 // User represents the user model stored in our database
 // This is used for user accounts, storing both an email
 // address and a password so users can log in and gain
@@ -31,26 +69,46 @@ type User struct {
 	gorm.Model
 	Username 	    	 string 		`gorm:"not null;type:varchar(100);unique_index"`
 	Email        		 string 		`gorm:"unique_index;type:varchar(100)"`
+	EmailVerified 		 bool 			`gorm:"not null;default:false"`
 	Password     		 string 		`gorm:"-" json:"-,omitempty"`
 	PasswordHash 		 string 		`gorm:"not null" json:"-"`
+	PepperVersion 		 int 			`gorm:"not null;default:0" json:"-"`
 	Token	     		 string 		`gorm:"-" json:"Token,omitempty"`
-	ChangedPassword  	 time.Time 		`gorm:"type:datetime" json:"-"`
-	Status	     		 StatusType		`gorm:"not null;type:ENUM('active', 'inactive', 'pending')" json:"-"`
+	TokenExpiresAt 		 time.Time 		`gorm:"-" json:"-"`
+	// Claims holds the claims of the token the user was
+	// authenticated with by ByToken or ByClaims, or those
+	// equivalent to the API key they used.
+	Claims 			 *JWTUser 		`gorm:"-" json:"-"`
+	ChangedPassword  	 time.Time 		`json:"-"`
+	TokenVersion 		 uint 			`gorm:"not null;default:0" json:"-"`
+	// Status is stored as text rather than an ENUM so the
+	// schema works on every database; the validator only lets
+	// known statuses through.
+	Status	     		 StatusType		`gorm:"not null;type:varchar(16)" json:"-"`
 }
 
 const (
-	tokenDuration = 72
-	issuer = "famistar"
+	// DefaultIssuer is used for the "iss" claim unless
+	// WithIssuer is used.
+	DefaultIssuer = "famistar"
 )
 
 type JWTUser struct {
-	ID 		 	 uint	`json:"id"`
+	ID 		 	 uint		`json:"id"`
+	Kind 		 TokenKind	`json:"kind,omitempty"`
+	Version 	 uint		`json:"ver"`
+	Username 	 string		`json:"username,omitempty"`
+	Status 		 StatusType	`json:"status,omitempty"`
+	Scope 		 string		`json:"scope,omitempty"`
+	ClientID 	 string		`json:"client_id,omitempty"`
 	jwt.StandardClaims
 }
 
+// Authentication holds the keys tokens are signed and
+// verified with. The signer is usually an *rsa.PrivateKey.
 type Authentication struct {
-	privateKey *rsa.PrivateKey
-	publicKey  *rsa.PublicKey
+	signer    crypto.Signer
+	publicKey *rsa.PublicKey
 }
 
 
@@ -70,73 +128,385 @@ type UserDB interface {
 // UserService is a set of methods used to manipulate and
 // work with the user model
 type UserService interface {
-	Authenticate(email, password string) (*User, error)
+	Authenticate(email, password string, opts ...TokenOption) (*User, error)
 	ByToken(token string) (*User, error)
-	ChangePassword(user *User, currentPassword, newPassword, validatePassword string) (*User, error)
-	GenerateToken(user *User) (error)
-	CreateUserWithToken(user *User) (error)
+	ByClaims(token string) (*User, error)
+	Introspect(token string) (*JWTUser, *User, error)
+	CacheStats() CacheStats
+	GenerateIDToken(user *User, clientID, nonce string, authTime time.Time) (string, error)
+	PublicKey() *rsa.PublicKey
+	PublicKeys() []*rsa.PublicKey
+	Issuer() string
+	// Reload replaces the settings tokens are issued and
+	// verified with while the service is running.
+	Reload(cfgs ...UserServiceConfig) error
+	GenerateServiceToken(client *OAuthClient, scope string) (string, time.Time, error)
+	ServiceByToken(token string) (*ServicePrincipal, error)
+	// ChangePassword signs the user out of every session,
+	// revokes their API keys and starts a new session for the
+	// device in opts.
+	ChangePassword(user *User, currentPassword, newPassword, validatePassword string, opts ...TokenOption) (*User, error)
+	// StartSession generates a token like GenerateToken and
+	// records the session it belongs to, so it is listed by
+	// Sessions and can be revoked.
+	StartSession(user *User, opts ...TokenOption) error
+	// Sessions lists the devices the user is signed in on and
+	// RevokeSession signs one of them out.
+	Sessions(userID uint) ([]Session, error)
+	RevokeSession(userID, id uint) error
+	RevokeSessionByTokenID(userID uint, tokenID string) error
+	// LoginHistory lists recent login attempts to the user's
+	// account, most recent first.
+	LoginHistory(userID uint, limit int) ([]LoginAttempt, error)
+	// PruneLoginHistory deletes the login attempts made before
+	// the given time.
+	PruneLoginHistory(before time.Time) (int64, error)
+	SetStatus(user *User, status StatusType) error
+	RevokeTokens(user *User) error
+	GenerateToken(user *User, opts ...TokenOption) (error)
+	CreateUserWithToken(user *User, opts ...TokenOption) (error)
 	UserDB
 }
 
 
-func NewUserService(db *gorm.DB, pepper string, hmacKey string, private *rsa.PrivateKey, public *rsa.PublicKey) UserService {
-	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacKey)
-	uv := newUserValidator(ug, hmac, pepper)
-	return &userService{
-		UserDB: uv,
-		pepper: pepper,
-		authentication: Authentication{
-			privateKey: private,
-			publicKey: public,
+// UserServiceConfig is used to customize a user service
+// created by NewUserService.
+type UserServiceConfig func(*userService)
+
+// WithPasswordHasher sets the hasher used to hash new
+// passwords and verify existing ones. By default passwords
+// are hashed with bcrypt.DefaultCost and Argon2id hashes are
+// still accepted.
+func WithPasswordHasher(hasher hash.PasswordHasher) UserServiceConfig {
+	return func(us *userService) {
+		us.hasher = hasher
+	}
+}
+
+// WithPeppers replaces the single pepper passed to
+// NewUserService with a set of versioned peppers. New
+// passwords are hashed with the current version, while
+// older versions are kept so existing users can still log
+// in and be migrated to the current pepper.
+func WithPeppers(values map[int]string, current int) UserServiceConfig {
+	return func(us *userService) {
+		us.peppers = peppers{
+			current: current,
+			values:  values,
+		}
+	}
+}
+
+// WithHMAC replaces the HMAC built from the single key passed
+// to NewUserService with a keyed set, so the key in use can
+// be rotated without invalidating existing digests.
+func WithHMAC(hmac hash.KeyedHMAC) UserServiceConfig {
+	return func(us *userService) {
+		us.hmac = hmac
+	}
+}
+
+// WithConcealedAccounts makes signup failures caused by an
+// email address or username that is already registered
+// indistinguishable from other signup failures, so the
+// signup form cannot be used to discover registered users.
+func WithConcealedAccounts(conceal bool) UserServiceConfig {
+	return func(us *userService) {
+		us.concealAccounts = conceal
+	}
+}
+
+// WithTokenValidation sets the clock skew tolerated when
+// validating token timestamps and the claims every token
+// must carry. A nil list means DefaultRequiredClaims, also
+// when reloading after a custom list was configured.
+func WithTokenValidation(leeway time.Duration, required []string) UserServiceConfig {
+	return func(us *userService) {
+		us.tokens.validator.Leeway = leeway
+		if required == nil {
+			required = DefaultRequiredClaims
+		}
+		us.tokens.validator.Required = required
+	}
+}
+
+// WithAudiences restricts tokens to audiences. own identifies
+// this service: tokens are issued for it by default and
+// ByToken only accepts tokens issued for it. registered lists
+// the other audiences clients may request tokens for.
+func WithAudiences(own string, registered []string) UserServiceConfig {
+	return func(us *userService) {
+		us.tokens.audiences = audiences{
+			own:        own,
+			registered: make(map[string]bool, len(registered)),
+			others:     registered,
+		}
+		for _, audience := range registered {
+			us.tokens.audiences.registered[audience] = true
+		}
+		us.tokens.validator.Audience = own
+	}
+}
+
+// WithIssuer sets the "iss" claim of issued tokens and the
+// only issuer accepted by ByToken.
+func WithIssuer(issuer string) UserServiceConfig {
+	return func(us *userService) {
+		us.tokens.issuer = issuer
+		us.tokens.validator.Issuer = issuer
+	}
+}
+
+// WithTokenLifetimes sets how long each kind of token is
+// valid. Zero lifetimes fall back to DefaultTokenLifetimes.
+// clients overrides the lifetimes for tokens requested with
+// ForClient; zero lifetimes there fall back to defaults.
+func WithTokenLifetimes(defaults TokenLifetimes, clients map[string]TokenLifetimes) UserServiceConfig {
+	return func(us *userService) {
+		us.tokens.lifetimes = tokenLifetimes{
+			defaults: defaults.orDefaults(DefaultTokenLifetimes),
+			clients:  clients,
+		}
+	}
+}
+
+// WithRevocationCache sets how long ByClaims trusts a cached
+// token version, and ByToken and Introspect a cached active
+// session, before checking the database again. It bounds how
+// long a revoked token can still be used on other instances.
+func WithRevocationCache(ttl time.Duration) UserServiceConfig {
+	return func(us *userService) {
+		us.versions = newVersionCache(ttl)
+		us.activeSessions = newSessionCache(ttl)
+	}
+}
+
+// WithUserCache puts a read-through cache of up to size
+// lookups in front of the users table. Cached users are kept
+// for ttl, so changes made by other instances, including
+// revoked tokens, may take that long to be noticed.
+func WithUserCache(size int, ttl time.Duration) UserServiceConfig {
+	return func(us *userService) {
+		us.cacheSize = size
+		us.cacheTTL = ttl
+	}
+}
+
+// WithUserDB stores users in udb, such as NewMemoryUserDB,
+// instead of the users table of the database. The cache and
+// validation still apply. Sessions and login history are
+// still stored in the database.
+func WithUserDB(udb UserDB) UserServiceConfig {
+	return func(us *userService) {
+		us.userDB = udb
+	}
+}
+
+func NewUserService(db *gorm.DB, pepper string, hmacKey string, signer crypto.Signer, public *rsa.PublicKey, cfgs ...UserServiceConfig) UserService {
+	us := &userService{
+		peppers: peppers{
+			values: map[int]string{0: pepper},
 		},
+		hmac: legacyHMAC(hmacKey),
+		hasher: hash.NewPasswords(hash.NewBcrypt(bcrypt.DefaultCost), hash.NewArgon2id(0, 0, 0)),
+		tokens: &tokenSettings{
+			authentication: Authentication{
+				signer: signer,
+				publicKey: public,
+			},
+			validator: *NewTokenValidator(DefaultIssuer),
+			issuer: DefaultIssuer,
+			lifetimes: tokenLifetimes{
+				defaults: DefaultTokenLifetimes,
+			},
+		},
+		versions: newVersionCache(defaultVersionCacheTTL),
+		activeSessions: newSessionCache(defaultVersionCacheTTL),
+		sessions: &sessionGorm{db},
+		logins: &loginHistoryGorm{db},
+		pendingLogins: make(chan struct{}, maxPendingLoginRecords),
+		apiKeys: &apiKeyService{db: db},
+	}
+	for _, cfg := range cfgs {
+		cfg(us)
 	}
+	var udb UserDB = &userGorm{db}
+	if us.userDB != nil {
+		udb = us.userDB
+	}
+	if us.cacheSize > 0 {
+		us.cache = newUserCache(udb, us.cacheSize, us.cacheTTL)
+		udb = us.cache
+	}
+	us.UserDB = newUserValidator(udb, us.hmac, us.hasher, us.peppers)
+	return us
+}
+
+// legacyHMAC wraps a single HMAC key in a keyed set under the
+// reserved empty key ID, producing unprefixed digests.
+func legacyHMAC(key string) hash.KeyedHMAC {
+	hmac, err := hash.NewKeyedHMAC(map[string]string{"": key}, "")
+	if err != nil {
+		panic(err)
+	}
+	return hmac
 }
 
 var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pepper  string
-	authentication Authentication
+	peppers peppers
+	hasher  hash.PasswordHasher
+	hmac    hash.KeyedHMAC
+	// tokens is replaced as a whole by Reload; read it with
+	// settings.
+	tokensMu sync.RWMutex
+	tokens *tokenSettings
+	versions *versionCache
+	activeSessions *sessionCache
+	sessions SessionDB
+	logins LoginHistoryDB
+	pendingLogins chan struct{}
+	apiKeys apiKeyRevoker
+	notifier LoginNotifier
+	userDB UserDB
+	cache *userCache
+	cacheSize int
+	cacheTTL time.Duration
+	concealAccounts bool
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+}
+
+// peppers holds every pepper that may still be in use by a
+// stored password hash, keyed by version.
+type peppers struct {
+	current int
+	values  map[int]string
+}
+
+// byVersion returns the pepper a password hash was created
+// with, or ErrPepperVersionUnknown if it was retired.
+func (p peppers) byVersion(version int) (string, error) {
+	pepper, ok := p.values[version]
+	if !ok {
+		return "", ErrPepperVersionUnknown
+	}
+	return pepper, nil
 }
 
 // Authenticate can be used to authenticate a user with the
-// provided email address and password.
-func (us *userService) Authenticate(email, password string) (*User, error) {
+// provided email address and password. If the stored hash
+// uses an outdated algorithm, parameters or pepper, the
+// password is rehashed with the current ones.
+//
+// Unknown email addresses and wrong passwords both return
+// ErrInvalidCredentials, and unknown email addresses still
+// go through a password comparison, so neither the response
+// nor its timing reveals whether an account exists.
+//
+// Attempts to existing accounts are recorded in the login
+// history, and successful ones from a new device or network
+// are reported to the LoginNotifier.
+func (us *userService) Authenticate(email, password string, opts ...TokenOption) (*User, error) {
 	foundUser, err := us.ByEmail(email)
+	if err == ErrNotFound {
+		us.compareDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+us.pepper))
-	if err != nil {
-		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrPasswordIncorrect
-		default:
+	if err := us.comparePassword(foundUser, password); err != nil {
+		if err == ErrPasswordIncorrect {
+			// Recorded in the background so the write does not make
+			// wrong passwords slower than unknown email addresses.
+			us.recordLoginAsync(foundUser, newTokenRequest(opts), LoginFailed)
+			return nil, ErrInvalidCredentials
+		}
+		if err == ErrPepperVersionUnknown {
+			// The password was hashed with a retired pepper. Telling
+			// the caller would reveal that the account exists.
+			log.Printf("models: user %d: %v", foundUser.ID, err)
+			us.compareDummyPassword(password)
+			us.recordLoginAsync(foundUser, newTokenRequest(opts), LoginFailed)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if us.hasher.NeedsRehash(foundUser.PasswordHash) || foundUser.PepperVersion != us.peppers.current {
+		if err := us.rehashPassword(foundUser, password); err != nil {
 			return nil, err
 		}
 	}
 
-	err = us.GenerateToken(foundUser);
+	err = us.StartSession(foundUser, opts...)
 	if err != nil {
 		return nil, err
 	}
+	us.recordLogin(foundUser, newTokenRequest(opts), LoginSucceeded)
 
 	return foundUser, nil
 }
 
-func (us *userService) ChangePassword(user *User, currentPassword, newPassword string, validatePassword string) (*User, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword+us.pepper))
+// comparePassword checks the password against the user's
+// stored hash using the pepper version it was hashed with.
+func (us *userService) comparePassword(user *User, password string) error {
+	pepper, err := us.peppers.byVersion(user.PepperVersion)
+	if err != nil {
+		return err
+	}
+	err = us.hasher.Compare(user.PasswordHash, password+pepper)
 	if err != nil {
 		switch err {
-		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrPasswordIncorrect
+		case hash.ErrMismatchedHashAndPassword:
+			return ErrPasswordIncorrect
 		default:
-			return nil, err
+			return err
 		}
 	}
+	return nil
+}
+
+// compareDummyPassword spends the same time as comparing a
+// password against a real hash from the current hasher. The
+// dummy hash is created on first use.
+func (us *userService) compareDummyPassword(password string) {
+	us.dummyHashOnce.Do(func() {
+		us.dummyHash, _ = us.hasher.Hash("dummy-password")
+	})
+	pepper, _ := us.peppers.byVersion(us.peppers.current)
+	us.hasher.Compare(us.dummyHash, password+pepper)
+}
+
+// rehashPassword stores a fresh hash of the already verified
+// password using the current hasher and pepper. The hash is
+// set directly so that validations meant for new passwords,
+// like the minimum length, do not lock out users with older
+// passwords.
+func (us *userService) rehashPassword(user *User, password string) error {
+	pepper, err := us.peppers.byVersion(us.peppers.current)
+	if err != nil {
+		return err
+	}
+	passwordHash, err := us.hasher.Hash(password + pepper)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = passwordHash
+	user.PepperVersion = us.peppers.current
+	return us.Update(user)
+}
+
+func (us *userService) ChangePassword(user *User, currentPassword, newPassword string, validatePassword string, opts ...TokenOption) (*User, error) {
+	err := us.comparePassword(user, currentPassword)
+	if err != nil {
+		return nil, err
+	}
 
 	if currentPassword == newPassword{
 		return nil, ErrCannotBeTheSameWithOldPassword
@@ -148,12 +518,20 @@ func (us *userService) ChangePassword(user *User, currentPassword, newPassword s
 
 	user.Password = newPassword
 	user.ChangedPassword = time.Now();
+	user.TokenVersion++
 	err = us.Update(user)
 	if err != nil {
 		return nil, err
 	}
+	us.versions.set(user.ID, user.TokenVersion)
+	if err := us.sessions.DeleteByUser(user.ID); err != nil {
+		return nil, err
+	}
+	if err := us.apiKeys.RevokeAll(user.ID); err != nil {
+		return nil, err
+	}
 
-	err = us.GenerateToken(user)
+	err = us.StartSession(user, opts...)
 
 	if err != nil {
 		return nil, err
@@ -162,6 +540,49 @@ func (us *userService) ChangePassword(user *User, currentPassword, newPassword s
 	return user, nil
 }
 
+// CacheStats returns the statistics of the user cache, or
+// zero values if it is disabled.
+func (us *userService) CacheStats() CacheStats {
+	if us.cache == nil {
+		return CacheStats{}
+	}
+	return us.cache.Stats()
+}
+
+// SetStatus changes the status of the user. Changing it, for
+// example when an admin locks an account, invalidates every
+// token issued to the user and revokes their API keys.
+func (us *userService) SetStatus(user *User, status StatusType) error {
+	if user.Status == status {
+		return nil
+	}
+	user.Status = status
+	user.TokenVersion++
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	us.versions.set(user.ID, user.TokenVersion)
+	if err := us.sessions.DeleteByUser(user.ID); err != nil {
+		return err
+	}
+	return us.apiKeys.RevokeAll(user.ID)
+}
+
+// RevokeTokens signs the user out everywhere by invalidating
+// every token issued to the user so far and revoking their API
+// keys.
+func (us *userService) RevokeTokens(user *User) error {
+	user.TokenVersion++
+	if err := us.Update(user); err != nil {
+		return err
+	}
+	us.versions.set(user.ID, user.TokenVersion)
+	if err := us.sessions.DeleteByUser(user.ID); err != nil {
+		return err
+	}
+	return us.apiKeys.RevokeAll(user.ID)
+}
+
 type userValFunc func(*User) error
 
 func runUserValFuncs(user *User, fns ...userValFunc) error {
@@ -175,20 +596,22 @@ func runUserValFuncs(user *User, fns ...userValFunc) error {
 
 var _ UserDB = &userValidator{}
 
-func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
+func newUserValidator(udb UserDB, hmac hash.KeyedHMAC, hasher hash.PasswordHasher, peppers peppers) *userValidator {
 	return &userValidator{
 		UserDB:     udb,
 		hmac:       hmac,
+		hasher:     hasher,
 		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
-		pepper:     pepper,
+		peppers:    peppers,
 	}
 }
 
 type userValidator struct {
 	UserDB
-	hmac       hash.HMAC
+	hmac       hash.KeyedHMAC
+	hasher     hash.PasswordHasher
 	emailRegex *regexp.Regexp
-	pepper     string
+	peppers    peppers
 }
 
 // ByEmail will normalize the email address before calling
@@ -210,14 +633,15 @@ func (uv *userValidator) Create(user *User) error {
 	err := runUserValFuncs(user,
 		uv.passwordRequired,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.hashPassword,
 		uv.passwordHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
 		uv.usernameIsAvail,
-		uv.requireUsername)
+		uv.requireUsername,
+		uv.statusValid)
 	if err != nil {
 		return err
 	}
@@ -228,14 +652,15 @@ func (uv *userValidator) Create(user *User) error {
 func (uv *userValidator) Update(user *User) error {
 	err := runUserValFuncs(user,
 		uv.passwordMinLength,
-		uv.bcryptPassword,
+		uv.hashPassword,
 		uv.passwordHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
 		uv.emailIsAvail,
 		uv.usernameIsAvail,
-		uv.requireUsername)
+		uv.requireUsername,
+		uv.statusValid)
 	if err != nil {
 		return err
 	}
@@ -253,19 +678,23 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-// bcryptPassword will hash a user's password with a
-// predefined pepper (userPwPepper) and bcrypt if the
-// Password field is not the empty string
-func (uv *userValidator) bcryptPassword(user *User) error {
+// hashPassword will hash a user's password with the current
+// pepper and the configured password hasher if the Password
+// field is not the empty string
+func (uv *userValidator) hashPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	pwBytes := []byte(user.Password + uv.pepper)
-	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	pepper, err := uv.peppers.byVersion(uv.peppers.current)
+	if err != nil {
+		return err
+	}
+	passwordHash, err := uv.hasher.Hash(user.Password + pepper)
 	if err != nil {
 		return err
 	}
-	user.PasswordHash = string(hashedBytes)
+	user.PasswordHash = passwordHash
+	user.PepperVersion = uv.peppers.current
 	user.Password = ""
 	return nil
 }
@@ -300,6 +729,13 @@ func (uv *userValidator) requireUsername(user *User) error {
 	return nil
 }
 
+func (uv *userValidator) statusValid(user *User) error {
+	if !user.Status.valid() {
+		return ErrStatusInvalid
+	}
+	return nil
+}
+
 func (uv *userValidator) emailFormat(user *User) error {
 	if user.Email == "" {
 		return nil
@@ -413,39 +849,17 @@ func (ug *userGorm) ByUsername(username string) (*User, error) {
 }
 
 
+// ByToken verifies the signature of the token, validates its
+// claims with the service's TokenValidator and returns the
+// user it was issued to. Tokens carrying a token version
+// other than the user's current one were revoked and are
+// rejected.
 func (us *userService) ByToken(tokenString string) (*User, error) {
-	jwtUser := JWTUser{}
-	token, err := jwt.ParseWithClaims(tokenString, &jwtUser, func(token *jwt.Token) (interface{}, error) {
-		return us.authentication.publicKey, nil
-	})
-
-	if err != nil {
-		return nil, ErrWrongToken
-	}
-
-	if jwtUser.ID < 1 && !token.Valid {
-		return nil, ErrPasswordRequired
-	}
-
-
-	if jwtUser.StandardClaims.ExpiresAt > time.Now().UnixNano() {
-		return nil, ErrTokenExpired
-	}
-
-	if jwtUser.StandardClaims.Issuer != issuer {
-		return nil, ErrWrongToken
-	}
-
-	foundUser, err := us.ByID(jwtUser.ID)
-	if jwtUser.StandardClaims.IssuedAt <= foundUser.ChangedPassword.Unix() {
-		return nil, ErrTokenExpired
-	}
-
+	jwtUser, err := us.parseToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
-
-	return foundUser, nil
+	return us.userForClaims(jwtUser)
 }
 
 // Create will create the provided user and backfill data
@@ -453,7 +867,7 @@ func (us *userService) ByToken(tokenString string) (*User, error) {
 func (ug *userGorm) Create(user *User) error {
 	err :=  ug.db.Create(user).Error
 	if err != nil{
-		return err
+		return uniqueViolation(err)
 	}
 	return nil
 }
@@ -461,7 +875,51 @@ func (ug *userGorm) Create(user *User) error {
 // Update will update the provided user with all of the data
 // in the provided user object.
 func (ug *userGorm) Update(user *User) error {
-	return ug.db.Save(user).Error
+	return uniqueViolation(ug.db.Save(user).Error)
+}
+
+// Error codes of unique index violations.
+const (
+	mysqlDuplicateEntry = 1062
+	pqUniqueViolation   = "23505"
+)
+
+// uniqueViolation turns a violation of the unique indexes of
+// the users table, as reported by the database driver, into
+// ErrUsernameTaken or ErrEmailTaken, like the userValidator
+// reports them. It catches the users that signed up in between
+// the validator's checks and the insert. Other errors are
+// returned as is.
+func uniqueViolation(err error) error {
+	var detail string
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		if e.Number != mysqlDuplicateEntry {
+			return err
+		}
+		// The message quotes the duplicate value before the key,
+		// which must not be mistaken for it.
+		detail = e.Message[strings.LastIndex(e.Message, " for key ")+1:]
+	case *pq.Error:
+		if e.Code != pqUniqueViolation {
+			return err
+		}
+		detail = e.Constraint
+	case sqlite3.Error:
+		if e.ExtendedCode != sqlite3.ErrConstraintUnique {
+			return err
+		}
+		detail = e.Error()
+	default:
+		return err
+	}
+	switch {
+	case strings.Contains(detail, "username"):
+		return ErrUsernameTaken
+	case strings.Contains(detail, "email"):
+		return ErrEmailTaken
+	}
+	return err
 }
 
 // Delete will delete the user with the provided ID
@@ -482,31 +940,61 @@ func first(db *gorm.DB, dst interface{}) error {
 }
 
 // Generate Token can be used to create a valid token for a user
-func (us *userService) GenerateToken(user *User) error{
+// Without ForAudience the token is issued for the service's
+// own audience. Without OfKind an access token is issued,
+// valid for the lifetime configured for the requesting
+// client.
+func (us *userService) GenerateToken(user *User, opts ...TokenOption) error{
+	req := newTokenRequest(opts)
+	settings := us.settings()
+	audience, err := settings.audiences.resolve(req.audience)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(settings.lifetimes.lifetime(req.client, req.kind))
 	token := jwt.NewWithClaims(jwt.SigningMethodRS512, &JWTUser{
 		ID: user.ID,
+		Kind: req.kind,
+		Version: user.TokenVersion,
+		Username: user.Username,
+		Status: user.Status,
+		Scope: req.scope,
+		ClientID: req.client,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(tokenDuration)).Unix(),
-			IssuedAt: time.Now().Unix(),
-			Issuer: issuer,
+			Id: req.tokenID,
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt: now.Unix(),
+			Issuer: settings.issuer,
+			Subject: strconv.FormatUint(uint64(user.ID), 10),
+			Audience: audience,
 		},
 	})
 
-	tokenString, err := token.SignedString(us.authentication.privateKey)
+	tokenString, err := settings.authentication.sign(token)
 	if err != nil {
 		return ErrSignedStringToken
 	}
 	user.Token = tokenString
+	user.TokenExpiresAt = time.Unix(expiresAt.Unix(), 0)
 	return nil
 }
 
 
-func (us *userService) CreateUserWithToken(user *User) error{
+// CreateUserWithToken creates the user and generates a token
+// for it. If accounts are concealed, errors revealing that
+// the email address or username is taken are replaced with
+// ErrSignupFailed.
+func (us *userService) CreateUserWithToken(user *User, opts ...TokenOption) error{
 	if err := us.Create(user); err != nil {
+		if us.concealAccounts && (err == ErrEmailTaken || err == ErrUsernameTaken) {
+			return ErrSignupFailed
+		}
 		return err
 	}
 
-	if err := us.GenerateToken(user); err != nil {
+	if err := us.StartSession(user, opts...); err != nil {
 		return err
 	}
 	return nil
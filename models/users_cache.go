@@ -0,0 +1,237 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats reports how a user cache has been performing.
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Size         int    `json:"size"`
+}
+
+var _ UserDB = &userCache{}
+
+func newUserCache(udb UserDB, size int, ttl time.Duration) *userCache {
+	return &userCache{
+		UserDB:  udb,
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		related: make(map[uint][]cacheKey),
+	}
+}
+
+// userCache is a read-through cache in front of a UserDB. It
+// keeps up to size lookups for ttl, evicting the least
+// recently used ones first. Lookups that returned ErrNotFound
+// are cached too. Create, Update and Delete invalidate every
+// entry that may refer to the affected user.
+//
+// Users are copied in and out of the cache, so callers are
+// free to modify the users they get back. Like the UserDB
+// implementations, a cached ErrNotFound comes with an empty
+// user.
+type userCache struct {
+	UserDB
+	size int
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	lru        *list.List
+	entries    map[cacheKey]*list.Element
+	related    map[uint][]cacheKey // cached lookups per user ID
+	generation uint64
+
+	hits, negativeHits, misses, evictions uint64
+}
+
+type cacheKeyKind uint8
+
+const (
+	cacheByID cacheKeyKind = iota
+	cacheByEmail
+	cacheByUsername
+)
+
+type cacheKey struct {
+	kind cacheKeyKind
+	id   uint
+	str  string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	user    *User // nil for a cached ErrNotFound
+	expires time.Time
+}
+
+// ByID will look up a user by ID in the cache before falling
+// back to the wrapped UserDB.
+func (uc *userCache) ByID(id uint) (*User, error) {
+	return uc.lookup(cacheKey{kind: cacheByID, id: id}, func() (*User, error) {
+		return uc.UserDB.ByID(id)
+	})
+}
+
+// ByEmail will look up a user by email in the cache before
+// falling back to the wrapped UserDB.
+func (uc *userCache) ByEmail(email string) (*User, error) {
+	return uc.lookup(cacheKey{kind: cacheByEmail, str: email}, func() (*User, error) {
+		return uc.UserDB.ByEmail(email)
+	})
+}
+
+// ByUsername will look up a user by username in the cache
+// before falling back to the wrapped UserDB.
+func (uc *userCache) ByUsername(username string) (*User, error) {
+	return uc.lookup(cacheKey{kind: cacheByUsername, str: username}, func() (*User, error) {
+		return uc.UserDB.ByUsername(username)
+	})
+}
+
+// Create will create the user and forget cached lookups that
+// did not find it.
+func (uc *userCache) Create(user *User) error {
+	err := uc.UserDB.Create(user)
+	uc.invalidate(user.ID, user.Email, user.Username)
+	return err
+}
+
+// Update will update the user and forget every cached lookup
+// of its old and new ID, email address and username.
+func (uc *userCache) Update(user *User) error {
+	err := uc.UserDB.Update(user)
+	uc.invalidate(user.ID, user.Email, user.Username)
+	return err
+}
+
+// Delete will delete the user and forget every cached lookup
+// of it.
+func (uc *userCache) Delete(id uint) error {
+	err := uc.UserDB.Delete(id)
+	uc.invalidate(id, "", "")
+	return err
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (uc *userCache) Stats() CacheStats {
+	uc.mu.Lock()
+	size := uc.lru.Len()
+	uc.mu.Unlock()
+	return CacheStats{
+		Hits:         atomic.LoadUint64(&uc.hits),
+		NegativeHits: atomic.LoadUint64(&uc.negativeHits),
+		Misses:       atomic.LoadUint64(&uc.misses),
+		Evictions:    atomic.LoadUint64(&uc.evictions),
+		Size:         size,
+	}
+}
+
+func (uc *userCache) lookup(key cacheKey, load func() (*User, error)) (*User, error) {
+	uc.mu.Lock()
+	if elem, ok := uc.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			uc.lru.MoveToFront(elem)
+			uc.mu.Unlock()
+			if entry.user == nil {
+				atomic.AddUint64(&uc.negativeHits, 1)
+				return &User{}, ErrNotFound
+			}
+			atomic.AddUint64(&uc.hits, 1)
+			user := *entry.user
+			return &user, nil
+		}
+		uc.removeElement(elem)
+	}
+	generation := uc.generation
+	uc.mu.Unlock()
+
+	atomic.AddUint64(&uc.misses, 1)
+	user, err := load()
+	switch err {
+	case nil:
+		uc.store(key, user, generation)
+	case ErrNotFound:
+		uc.store(key, nil, generation)
+	}
+	return user, err
+}
+
+// store caches the result of a lookup unless the cache was
+// invalidated while it was being loaded, in which case the
+// result may already be stale.
+func (uc *userCache) store(key cacheKey, user *User, generation uint64) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if generation != uc.generation {
+		return
+	}
+	entry := &cacheEntry{key: key, expires: time.Now().Add(uc.ttl)}
+	if user != nil {
+		cached := *user
+		entry.user = &cached
+	}
+	if elem, ok := uc.entries[key]; ok {
+		uc.removeElement(elem)
+	}
+	uc.entries[key] = uc.lru.PushFront(entry)
+	if user != nil {
+		uc.related[user.ID] = append(uc.related[user.ID], key)
+	}
+	for uc.lru.Len() > uc.size {
+		uc.removeElement(uc.lru.Back())
+		atomic.AddUint64(&uc.evictions, 1)
+	}
+}
+
+// invalidate forgets the lookups of the given ID, email
+// address and username, along with every other lookup that
+// returned the same user.
+func (uc *userCache) invalidate(id uint, email, username string) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.generation++
+	keys := append([]cacheKey{{kind: cacheByID, id: id}}, uc.related[id]...)
+	if email != "" {
+		keys = append(keys, cacheKey{kind: cacheByEmail, str: email})
+	}
+	if username != "" {
+		keys = append(keys, cacheKey{kind: cacheByUsername, str: username})
+	}
+	for _, key := range keys {
+		if elem, ok := uc.entries[key]; ok {
+			uc.removeElement(elem)
+		}
+	}
+	delete(uc.related, id)
+}
+
+func (uc *userCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	uc.lru.Remove(elem)
+	delete(uc.entries, entry.key)
+	if entry.user == nil {
+		return
+	}
+	keys := uc.related[entry.user.ID]
+	for i, key := range keys {
+		if key == entry.key {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(uc.related, entry.user.ID)
+	} else {
+		uc.related[entry.user.ID] = keys
+	}
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// countingUserDB is a UserDB backed by a map that counts the
+// lookups reaching it.
+type countingUserDB struct {
+	UserDB
+	users   map[uint]User
+	lookups int
+}
+
+func (db *countingUserDB) ByID(id uint) (*User, error) {
+	db.lookups++
+	user, ok := db.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (db *countingUserDB) ByEmail(email string) (*User, error) {
+	db.lookups++
+	for _, user := range db.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (db *countingUserDB) Create(user *User) error {
+	user.ID = uint(len(db.users) + 1)
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *countingUserDB) Update(user *User) error {
+	db.users[user.ID] = *user
+	return nil
+}
+
+func (db *countingUserDB) Delete(id uint) error {
+	delete(db.users, id)
+	return nil
+}
+
+func TestUserCache(t *testing.T) {
+	db := &countingUserDB{users: map[uint]User{}}
+	uc := newUserCache(db, 2, time.Minute)
+
+	if _, err := uc.ByEmail("new@test.com"); err != ErrNotFound {
+		t.Fatalf("ByEmail() = %v; want %v", err, ErrNotFound)
+	}
+	if _, err := uc.ByEmail("new@test.com"); err != ErrNotFound || db.lookups != 1 {
+		t.Fatalf("ByEmail() should serve ErrNotFound from the cache, got %v after %d lookups", err, db.lookups)
+	}
+
+	user := User{Email: "new@test.com", Username: "new"}
+	if err := uc.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.ByEmail("new@test.com"); err != nil {
+		t.Fatalf("ByEmail() after Create = %v; want nil", err)
+	}
+
+	cached, err := uc.ByID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Username = "changed"
+	if again, _ := uc.ByID(user.ID); again.Username != "new" {
+		t.Errorf("modifying a returned user changed the cached one")
+	}
+
+	user.Email = "renamed@test.com"
+	if err := uc.Update(&user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.ByEmail("new@test.com"); err != ErrNotFound {
+		t.Errorf("ByEmail() with the old email after Update = %v; want %v", err, ErrNotFound)
+	}
+	if found, err := uc.ByID(user.ID); err != nil || found.Email != "renamed@test.com" {
+		t.Errorf("ByID() after Update = %v, %v; want the updated user", found, err)
+	}
+
+	if err := uc.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.ByID(user.ID); err != ErrNotFound {
+		t.Errorf("ByID() after Delete = %v; want %v", err, ErrNotFound)
+	}
+
+	stats := uc.Stats()
+	if stats.Hits == 0 || stats.NegativeHits == 0 || stats.Misses == 0 {
+		t.Errorf("Stats() = %+v; want hits, negative hits and misses", stats)
+	}
+}
+
+func TestUserCacheEviction(t *testing.T) {
+	db := &countingUserDB{users: map[uint]User{1: {}, 2: {}, 3: {}}}
+	uc := newUserCache(db, 2, time.Minute)
+	uc.ByID(1)
+	uc.ByID(2)
+	uc.ByID(1)
+	uc.ByID(3)
+	uc.ByID(1)
+	if db.lookups != 3 {
+		t.Errorf("recently used entries should be kept, got %d lookups; want 3", db.lookups)
+	}
+	if stats := uc.Stats(); stats.Size != 2 || stats.Evictions != 1 {
+		t.Errorf("Stats() = %+v; want 2 entries and 1 eviction", stats)
+	}
+}
+
+func TestUserCacheExpiry(t *testing.T) {
+	db := &countingUserDB{users: map[uint]User{1: {Email: "a@test.com"}}}
+	uc := newUserCache(db, 10, -time.Second)
+	uc.ByID(1)
+	uc.ByID(1)
+	if db.lookups != 2 {
+		t.Errorf("expired entries should be reloaded, got %d lookups; want 2", db.lookups)
+	}
+}
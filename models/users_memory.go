@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// NewMemoryUserDB returns a UserDB kept in memory, for tests
+// and local development. It behaves like the users table: IDs
+// and timestamps are set on create, usernames and email
+// addresses are unique, and deleted users are soft deleted, so
+// their username and email address stay taken. It is safe for
+// concurrent use.
+func NewMemoryUserDB() UserDB {
+	return &memoryUserDB{users: make(map[uint]User)}
+}
+
+type memoryUserDB struct {
+	mu     sync.RWMutex
+	lastID uint
+	// users holds deleted users too, as the unique constraints
+	// of the users table apply to them.
+	users map[uint]User
+}
+
+var _ UserDB = &memoryUserDB{}
+
+func (mdb *memoryUserDB) ByID(id uint) (*User, error) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	user, ok := mdb.users[id]
+	if !ok || user.DeletedAt != nil {
+		return &User{}, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (mdb *memoryUserDB) ByEmail(email string) (*User, error) {
+	return mdb.find(func(user *User) bool { return user.Email == email })
+}
+
+func (mdb *memoryUserDB) ByUsername(username string) (*User, error) {
+	return mdb.find(func(user *User) bool { return user.Username == username })
+}
+
+// find returns the user with the lowest ID matching, like
+// gorm's First.
+func (mdb *memoryUserDB) find(match func(*User) bool) (*User, error) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	var found *User
+	for _, user := range mdb.users {
+		user := user
+		if user.DeletedAt != nil || !match(&user) {
+			continue
+		}
+		if found == nil || user.ID < found.ID {
+			found = &user
+		}
+	}
+	if found == nil {
+		return &User{}, ErrNotFound
+	}
+	return found, nil
+}
+
+// Create backfills the ID, CreatedAt and UpdatedAt fields.
+func (mdb *memoryUserDB) Create(user *User) error {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	return mdb.create(user)
+}
+
+func (mdb *memoryUserDB) create(user *User) error {
+	if user.ID != 0 {
+		if _, ok := mdb.users[user.ID]; ok {
+			return fmt.Errorf("models: user ID %d is already taken", user.ID)
+		}
+	}
+	if err := mdb.checkUnique(user); err != nil {
+		return err
+	}
+	if user.ID == 0 {
+		user.ID = mdb.lastID + 1
+	}
+	if user.ID > mdb.lastID {
+		mdb.lastID = user.ID
+	}
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+	mdb.users[user.ID] = stored(user)
+	return nil
+}
+
+// Update saves every field of the user. Like gorm's Save, it
+// creates users that do not exist yet.
+func (mdb *memoryUserDB) Update(user *User) error {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	existing, ok := mdb.users[user.ID]
+	if user.ID == 0 || !ok || existing.DeletedAt != nil {
+		return mdb.create(user)
+	}
+	if err := mdb.checkUnique(user); err != nil {
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	mdb.users[user.ID] = stored(user)
+	return nil
+}
+
+// Delete soft deletes the user. Deleting a user that does not
+// exist is not an error.
+func (mdb *memoryUserDB) Delete(id uint) error {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	user, ok := mdb.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	user.DeletedAt = &now
+	mdb.users[id] = user
+	return nil
+}
+
+// checkUnique enforces the unique indexes of the users table,
+// which cover deleted users too.
+func (mdb *memoryUserDB) checkUnique(user *User) error {
+	for id, other := range mdb.users {
+		if id == user.ID {
+			continue
+		}
+		if other.Username == user.Username {
+			return ErrUsernameTaken
+		}
+		if other.Email == user.Email {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// stored returns the copy of user kept in memory, without the
+// fields that are not stored in the users table.
+func stored(user *User) User {
+	u := *user
+	u.Password = ""
+	u.Token = ""
+	u.TokenExpiresAt = time.Time{}
+	u.Claims = nil
+	return u
+}
@@ -1,32 +1,87 @@
 package models
 
 import (
-	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"testing"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"time"
 	"golang-jwt-api/config"
 	"github.com/jinzhu/gorm"
 )
 
 var userServiceTest UserService
+// mockDb is only set when tests run against the database
+// configured by $APP_TEST_CONFIG; otherwise users are kept in
+// mockUserDB.
 var mockDb *gorm.DB
+var mockUserDB UserDB
+var mockSessions *fakeSessionDB
+var mockLogins *fakeLoginHistoryDB
 var mockConfig config.Config
+var mockPrivateKey *rsa.PrivateKey
+var mockPublicKey *rsa.PublicKey
 
 func init()  {
-	testCfg := config.LoadTestConfig();
-	db := config.GetMockDatabase(testCfg.Database)
-	us := NewUserService(db, testCfg.Pepper, testCfg.HMACKey, testCfg.GetPrivateKey(), testCfg.GetPublicKey())
-	db.LogMode(false)
-	// Clear the users table between tests
-	err := db.DropTableIfExists(&User{}).Error
+	if _, ok := os.LookupEnv(config.EnvTestConfigPath); ok {
+		initDatabase()
+	} else {
+		initMemory()
+	}
+	userServiceTest = newTestUserService()
+}
+
+// initMemory keeps users in memory, so tests need no database.
+func initMemory() {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	mockPrivateKey, mockPublicKey = key, &key.PublicKey
+	mockConfig = config.Config{Pepper: "test-pepper", HMACKey: "test-hmac"}
+	mockUserDB = NewMemoryUserDB()
+	mockSessions = &fakeSessionDB{sessions: make(map[string]*Session)}
+	mockLogins = &fakeLoginHistoryDB{}
+}
+
+// initDatabase runs the tests against the database configured
+// by $APP_TEST_CONFIG, clearing every table first.
+func initDatabase() {
+	testCfg, err := config.LoadTestConfig()
+	if err != nil {
+		panic(err)
+	}
+	db, err := config.GetMockDatabase(testCfg.Database)
+	if err != nil {
+		panic(err)
+	}
+	mockPrivateKey, mockPublicKey, err = testCfg.GetKeys()
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&User{})
-	userServiceTest = us
+	db.LogMode(false)
+	services := &Services{db: db}
+	if err := services.DestructiveReset(); err != nil {
+		panic(err)
+	}
 	mockDb = db
 	mockConfig = testCfg
 }
 
+// newTestUserService returns a user service sharing the test
+// store with userServiceTest.
+func newTestUserService(cfgs ...UserServiceConfig) UserService {
+	if mockDb != nil {
+		return NewUserService(mockDb, mockConfig.Pepper, mockConfig.HMACKey, mockPrivateKey, mockPublicKey, cfgs...)
+	}
+	cfgs = append([]UserServiceConfig{WithUserDB(mockUserDB)}, cfgs...)
+	us := NewUserService(nil, mockConfig.Pepper, mockConfig.HMACKey, mockPrivateKey, mockPublicKey, cfgs...).(*userService)
+	us.sessions = mockSessions
+	us.logins = mockLogins
+	us.apiKeys = &fakeAPIKeyRevoker{}
+	return us
+}
+
 func TestCreate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -42,6 +97,7 @@ func TestCreate(t *testing.T) {
 		{"Create a user with wrong email", User{Username: "test2", Email: "test", Password: "12345678"}, ErrEmailInvalid, true},
 		{"Create a user without email", User{Username: "test2", Email: "", Password: "12345678"}, ErrEmailRequired, true},
 		{"Create a user without username", User{Username: "", Email: "test22@email.com", Password: "12345678"}, ErrUsernameRequired, true},
+		{"Create a user with an unknown status", User{Username: "test3", Email: "test3@test.com", Password: "12345678", Status: "banned"}, ErrStatusInvalid, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -97,7 +153,10 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestUserGorm_ByUsername(t *testing.T) {
-	var user User
+	udb := mockUserDB
+	if mockDb != nil {
+		udb = &userGorm{mockDb}
+	}
 	tests := []struct {
 		name    	string
 		args	    string
@@ -109,8 +168,7 @@ func TestUserGorm_ByUsername(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			query := mockDb.Where("username = ?", tt.args)
-			err := first(query, &user)
+			_, err := udb.ByUsername(tt.args)
 			if (err != nil) != tt.wantErr || err != tt.want {
 				t.Errorf("UserTest() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -157,7 +215,9 @@ func TestUserService_Authenticate(t *testing.T) {
 		want    interface{}
 		wantErr bool
 	}{
-		{"Authenticate a user", LoginFormTest{email:"test@email.com" , password: "12345678"}, nil, false},
+		{"Authenticate a user", LoginFormTest{email:"test@test.com" , password: "12345678"}, nil, false},
+		{"Authenticate a user with wrong password", LoginFormTest{email:"test@test.com" , password: "87654321"}, ErrInvalidCredentials, true},
+		{"Authenticate an unknown email", LoginFormTest{email:"unknown@test.com" , password: "12345678"}, ErrInvalidCredentials, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -168,4 +228,143 @@ func TestUserService_Authenticate(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestUserService_PepperRotation(t *testing.T) {
+	user := User{Username: "pepper", Email: "pepper@test.com", Password: "12345678"}
+	if err := userServiceTest.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	peppers := map[int]string{0: mockConfig.Pepper, 1: "new-pepper"}
+	rotated := newTestUserService(WithPeppers(peppers, 1))
+	found, err := rotated.Authenticate(user.Email, "12345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.PepperVersion != 1 {
+		t.Errorf("PepperVersion = %d; want 1", found.PepperVersion)
+	}
+
+	retired := newTestUserService(WithPeppers(map[int]string{1: "new-pepper"}, 1))
+	if _, err := retired.Authenticate(user.Email, "12345678"); err != nil {
+		t.Errorf("Authenticate() after rehash with retired pepper = %v; want nil", err)
+	}
+
+	// Users whose pepper was retired before they logged in look
+	// like wrong credentials, so the account is not revealed.
+	stale := User{Username: "stale-pepper", Email: "stale-pepper@test.com", Password: "12345678"}
+	if err := userServiceTest.Create(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Authenticate(stale.Email, "12345678"); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() with a retired pepper = %v; want ErrInvalidCredentials", err)
+	}
+	// The failed attempt is recorded in the background.
+	deadline := time.Now().Add(time.Second)
+	for {
+		history, err := retired.LoginHistory(stale.ID, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) == 1 && history[0].Outcome == LoginFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("LoginHistory() = %+v; want the failed attempt", history)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUserService_RevokeTokens(t *testing.T) {
+	user, err := userServiceTest.ByUsername("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := userServiceTest.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	token := user.Token
+	if _, err := userServiceTest.ByToken(token); err != nil {
+		t.Fatalf("ByToken() before revoking = %v; want nil", err)
+	}
+
+	if err := userServiceTest.RevokeTokens(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userServiceTest.ByToken(token); err != ErrTokenRevoked {
+		t.Errorf("ByToken() after revoking = %v; want %v", err, ErrTokenRevoked)
+	}
+
+	if err := userServiceTest.GenerateToken(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userServiceTest.ByToken(user.Token); err != nil {
+		t.Errorf("ByToken() with a token issued after revoking = %v; want nil", err)
+	}
+}
+
+func TestUserService_RevokeTokensRevokesAPIKeys(t *testing.T) {
+	us := newTestUserService().(*userService)
+	keys := &fakeAPIKeyRevoker{}
+	us.apiKeys = keys
+	user := User{Username: "keys", Email: "keys@test.com", Password: "12345678"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.RevokeTokens(&user); err != nil {
+		t.Fatal(err)
+	}
+	if keys.count(user.ID) != 1 {
+		t.Error("RevokeTokens() should revoke the API keys of the user")
+	}
+	if _, err := us.ChangePassword(&user, "12345678", "87654321", "87654321"); err != nil {
+		t.Fatal(err)
+	}
+	if keys.count(user.ID) != 2 {
+		t.Error("ChangePassword() should revoke the API keys of the user")
+	}
+	if err := us.SetStatus(&user, Inactive); err != nil {
+		t.Fatal(err)
+	}
+	if keys.count(user.ID) != 3 {
+		t.Error("SetStatus() should revoke the API keys of the user")
+	}
+}
+
+func TestUserService_OAuthToken(t *testing.T) {
+	user := User{Username: "oauth", Email: "oauth@test.com", Password: "12345678"}
+	if err := userServiceTest.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	err := userServiceTest.GenerateToken(&user, OfKind(OAuthToken), ForClient("app"), ForScope("openid email"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := userServiceTest.ByToken(user.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID || found.Claims.Kind != OAuthToken || found.Claims.Scope != "openid email" || found.Claims.ClientID != "app" {
+		t.Errorf("ByToken() = %+v, claims %+v; want the OAuth token's claims", found, found.Claims)
+	}
+	if _, err := userServiceTest.ServiceByToken(user.Token); err != ErrTokenKindInvalid {
+		t.Errorf("ServiceByToken() of an OAuth token = %v; want ErrTokenKindInvalid", err)
+	}
+}
+
+func TestStatusType_Scan(t *testing.T) {
+	// MySQL and PostgreSQL return text as bytes, SQLite as a
+	// string.
+	for _, value := range []interface{}{[]byte("active"), "active"} {
+		var status StatusType
+		if err := status.Scan(value); err != nil || status != Active {
+			t.Errorf("Scan(%#v) = %q, %v; want %q", value, status, err, Active)
+		}
+	}
+	var status StatusType
+	if err := status.Scan(42); err == nil {
+		t.Error("Scan() of a number should fail")
+	}
+}
@@ -0,0 +1,30 @@
+package rand
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// Bytes will help us generate n random bytes, or will
+// return an error if there was one. This uses the
+// crypto/rand package so it is safe to use with things
+// like secrets and authorization codes.
+func Bytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// String will generate a byte slice of size nBytes and then
+// return a string that is the base64 URL encoded version
+// of that byte slice
+func String(nBytes int) (string, error) {
+	b, err := Bytes(nBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rsa"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang-jwt-api/config"
+	"golang-jwt-api/models"
+)
+
+// reloadable lists the settings, by path or path prefix, that
+// are applied to the running server by a reload. Changes to
+// any other setting need a restart.
+var reloadable = []string{
+	"env",
+	"jwt.private",
+	"jwt.public",
+	"jwt.private_passphrase",
+	"jwt.leeway",
+	"jwt.required_claims",
+	"jwt.audience",
+	"jwt.audiences",
+	"jwt.issuer",
+	"jwt.lifetimes",
+	"jwt.clients",
+	"jwt.revocation_cache_ttl",
+}
+
+// watchInterval is how often the configuration file and keys
+// are checked for changes.
+const watchInterval = 5 * time.Second
+
+// reloader reloads the configuration into the running
+// services on SIGHUP or when one of its files changes.
+type reloader struct {
+	flags    config.Flags
+	services *models.Services
+
+	mu      sync.Mutex
+	current config.Config
+}
+
+// reload loads the configuration again and applies its
+// reloadable settings. An invalid configuration is rejected
+// and the settings in use are kept.
+func (rl *reloader) reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	cfg, err := config.Load(rl.flags, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	privateKey, publicKey, err := cfg.GetKeys()
+	if err != nil {
+		return err
+	}
+	oldPublicKey := rl.services.User.PublicKey()
+	if err := rl.services.User.Reload(tokenSettings(cfg, privateKey, publicKey)...); err != nil {
+		return err
+	}
+	rl.services.SetLogMode(!cfg.IsProd())
+
+	for _, change := range config.Diff(rl.current, cfg) {
+		if isReloadable(change.Path) {
+			log.Printf("reload: %s", change)
+		} else {
+			log.Printf("reload: %s needs a restart to take effect", change)
+		}
+	}
+	if !oldPublicKey.Equal(publicKey) {
+		log.Printf("reload: new signing key %s", models.KeyID(publicKey))
+	}
+	rl.current = cfg
+	return nil
+}
+
+func isReloadable(path string) bool {
+	for _, p := range reloadable {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// files returns the files whose changes trigger a reload.
+func (rl *reloader) files() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return []string{
+		config.Path(rl.flags, os.LookupEnv),
+		rl.current.Jwt.PrivateKey,
+		rl.current.Jwt.PublicKey,
+	}
+}
+
+// run reloads the configuration on SIGHUP and whenever the
+// configuration file or the keys change. It never returns.
+func (rl *reloader) run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	seen := modTimes(rl.files())
+	for {
+		select {
+		case <-hup:
+			log.Println("reload: SIGHUP received")
+		case <-ticker.C:
+			current := modTimes(rl.files())
+			if equalModTimes(seen, current) {
+				continue
+			}
+			log.Println("reload: configuration files changed")
+		}
+		if err := rl.reload(); err != nil {
+			log.Printf("reload: keeping the current configuration: %v", err)
+		}
+		seen = modTimes(rl.files())
+	}
+}
+
+// modTimes returns when each file was last modified. Missing
+// files are recorded with a zero time.
+func modTimes(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		times[path] = modTime
+	}
+	return times
+}
+
+func equalModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, t := range a {
+		if other, ok := b[path]; !ok || !other.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenSettings returns the user service options that can be
+// changed by a reload.
+func tokenSettings(cfg config.Config, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) []models.UserServiceConfig {
+	return []models.UserServiceConfig{
+		models.WithKeys(privateKey, publicKey),
+		models.WithTokenValidation(cfg.Jwt.Leeway.Duration, cfg.Jwt.RequiredClaims),
+		models.WithAudiences(cfg.Jwt.Audience, cfg.Jwt.Audiences),
+		models.WithIssuer(issuer(cfg.Jwt)),
+		models.WithTokenLifetimes(tokenLifetimes(cfg.Jwt)),
+		models.WithRevocationCache(revocationCacheTTL(cfg.Jwt)),
+	}
+}
@@ -0,0 +1,60 @@
+#!/bin/sh
+# Runs the models tests against MySQL and PostgreSQL, each in a
+# throwaway Docker container. Pass "mysql" or "postgres" to run
+# only one of them; extra arguments go to go test.
+set -eu
+
+cd "$(dirname "$0")/.."
+root=$(pwd)
+dialects=${1:-"mysql postgres"}
+[ $# -gt 0 ] && shift
+
+config=$(mktemp)
+trap 'rm -f "$config"; docker rm -f famistar-test-mysql famistar-test-postgres >/dev/null 2>&1 || true' EXIT
+cat >"$config" <<JSON
+{
+  "port": 3000,
+  "env": "dev",
+  "pepper": "test-pepper",
+  "hmac_key": "test-hmac",
+  "database": {"host": "127.0.0.1", "user": "famistar", "password": "famistar", "name": "famistar_test"},
+  "jwt": {"private": "$root/keys/testdata/pkcs1.pem", "public": "$root/keys/testdata/public.pem"}
+}
+JSON
+
+# wait_for runs its arguments until they succeed, for at most a
+# minute.
+wait_for() {
+	for _ in $(seq 60); do
+		"$@" >/dev/null 2>&1 && return 0
+		sleep 1
+	done
+	echo "timed out waiting for: $*" >&2
+	return 1
+}
+
+for dialect in $dialects; do
+	case $dialect in
+	mysql)
+		docker run -d --rm --name famistar-test-mysql -p 13306:3306 \
+			-e MYSQL_ROOT_PASSWORD=root -e MYSQL_USER=famistar -e MYSQL_PASSWORD=famistar \
+			-e MYSQL_DATABASE=famistar_test mysql:8.0 >/dev/null
+		wait_for docker exec famistar-test-mysql mysql -ufamistar -pfamistar -e "SELECT 1" famistar_test
+		port=13306
+		;;
+	postgres)
+		docker run -d --rm --name famistar-test-postgres -p 15432:5432 \
+			-e POSTGRES_USER=famistar -e POSTGRES_PASSWORD=famistar \
+			-e POSTGRES_DB=famistar_test postgres:15 >/dev/null
+		wait_for docker exec famistar-test-postgres pg_isready -U famistar -d famistar_test
+		port=15432
+		;;
+	*)
+		echo "unknown dialect $dialect, want mysql or postgres" >&2
+		exit 2
+		;;
+	esac
+	echo "== $dialect"
+	APP_TEST_CONFIG=$config APP_DATABASE_TYPE=$dialect APP_DATABASE_PORT=$port \
+		APP_DATABASE_SSL_MODE=disable go test -count=1 "$@" ./models/...
+done
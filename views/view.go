@@ -5,6 +5,20 @@ import (
 	"encoding/json"
 )
 
+// RenderJSON writes data as is with the given status code,
+// for responses whose format is fixed by a specification and
+// cannot be wrapped in Data.
+func RenderJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	response, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, AlertMsgGeneric, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
 // Render is used to render the view with the predefined layout.
 func Render(w http.ResponseWriter, r *http.Request, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")